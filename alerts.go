@@ -0,0 +1,109 @@
+// This file handles the alerts sent to the admin channel whenever a target breaks or goes stale.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hermitpopcorn/decatholac-mango/database"
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+type alertConfiguration struct {
+	Channel          string // The channel ID to post alerts to; alerts are only printed if empty
+	FailureThreshold int    // Alert after this many consecutive failed runs
+	MinimumChapters  int    // Alert when a run finds nothing after a run that found at least this many
+}
+
+// How many runs to look back at when looking for the last healthy run.
+const alertLookback = 20
+
+// Gets the alert configuration with defaults filled in.
+func getAlertConfiguration() alertConfiguration {
+	alerts := getConfig().Alerts
+	if alerts.FailureThreshold < 1 {
+		alerts.FailureThreshold = 3
+	}
+	if alerts.MinimumChapters < 1 {
+		alerts.MinimumChapters = 3
+	}
+
+	return alerts
+}
+
+// Checks whether the runs (newest first) have just reached the failure threshold.
+// It only returns true on the run that reaches it, so the same streak doesn't alert again on every run.
+func hasJustBroken(runs []types.FetchRun, threshold int) bool {
+	if len(runs) < threshold {
+		return false
+	}
+
+	for _, run := range runs[:threshold] {
+		if run.Error == "" {
+			return false
+		}
+	}
+
+	return len(runs) == threshold || runs[threshold].Error == ""
+}
+
+// Checks whether the newest run found nothing while the last healthy run before it found many.
+// Runs that failed or were answered with 304 Not Modified tell nothing about the parser, so they're skipped.
+// The chapters are counted before the target's filters, so filters that drop everything don't look like a broken parser.
+func hasJustGoneStale(runs []types.FetchRun, minimumChapters int) (bool, int) {
+	if len(runs) < 2 || runs[0].Error != "" || runs[0].HttpStatus == 304 || runs[0].ChaptersFound > 0 {
+		return false, 0
+	}
+
+	for _, run := range runs[1:] {
+		if run.Error != "" || run.HttpStatus == 304 {
+			continue
+		}
+
+		return run.ChaptersFound >= minimumChapters, run.ChaptersFound
+	}
+
+	return false, 0
+}
+
+// Sends an alert to the admin channel, and prints it regardless.
+func sendAlert(message string) {
+	fmt.Println(helpers.FormattedNow(), "ALERT:", message)
+
+	channel := getAlertConfiguration().Channel
+	if channel == "" || session == nil {
+		return
+	}
+
+	_, err := session.ChannelMessageSend(channel, ":warning: "+message)
+	if err != nil {
+		fmt.Println(helpers.FormattedNow(), "Failed sending alert:", err.Error())
+	}
+}
+
+// Looks at the recent fetch runs of a target and alerts if it has broken or gone stale.
+func checkTargetHealth(db database.Database, target string) {
+	alerts := getAlertConfiguration()
+
+	lookback := alertLookback
+	if alerts.FailureThreshold+1 > lookback {
+		lookback = alerts.FailureThreshold + 1
+	}
+
+	runs, err := db.GetFetchRuns(target, lookback)
+	if err != nil {
+		fmt.Println(helpers.FormattedNow(), target+":", "Failed checking target health:", err.Error())
+		return
+	}
+
+	if hasJustBroken(runs, alerts.FailureThreshold) {
+		sendAlert("[" + target + "] has failed " + strconv.Itoa(alerts.FailureThreshold) + " consecutive fetches. Last error: " + runs[0].Error)
+		return
+	}
+
+	if stale, previous := hasJustGoneStale(runs, alerts.MinimumChapters); stale {
+		sendAlert("[" + target + "] found no chapters, but it found " + strconv.Itoa(previous) + " before. The source's layout might have changed.")
+	}
+}
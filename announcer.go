@@ -3,25 +3,85 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/hermitpopcorn/decatholac-mango/database"
 	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/parsers"
 	"github.com/hermitpopcorn/decatholac-mango/types"
 )
 
-// Announce a single chapter to a certain guild's feed channel.
-func announceChapter(session *discordgo.Session, server *types.Server, chapter *types.Chapter) (*discordgo.Message, error) {
-	message, err := session.ChannelMessageSendEmbed(server.FeedChannelIdentifier, &discordgo.MessageEmbed{
-		Type:      discordgo.EmbedTypeLink,
-		URL:       chapter.Url,
-		Title:     "[" + chapter.Manga + "] " + chapter.Title,
-		Timestamp: chapter.Date.In(time.FixedZone("JST", 9*60*60)).Format(time.RFC3339),
-	})
+// How long an announcer can hold a guild before others may take it over.
+// The lease is renewed after every announced chapter, so this only needs to cover a single one;
+// a lease left behind by a dead process expires after this.
+const announcingLeaseDuration = 10 * time.Minute
+
+var leaseCounter uint64
+
+// Makes a lease owner name that's unique to this process and this announcer.
+func newLeaseOwner() string {
+	return instanceId + "#" + strconv.FormatUint(atomic.AddUint64(&leaseCounter, 1), 10)
+}
+
+// Announcements show this much of a chapter's description at most.
+const maxEmbedDescriptionLength = 300
+
+// Discord refuses embed titles, author names and field values longer than these.
+const (
+	maxEmbedTitleLength      = 256
+	maxEmbedFieldValueLength = 1024
+)
+
+// Makes the embed that announces a chapter, in the guild's language and timezone.
+// The series' name is shown with the target's icon, and the embed takes the target's color.
+func makeChapterEmbed(server *types.Server, chapter *types.Chapter) *discordgo.MessageEmbed {
+	lang := getLanguage(server, nil)
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		URL:         chapter.Url,
+		Title:       truncateText(chapter.Title, maxEmbedTitleLength),
+		Description: truncateText(chapter.Description, maxEmbedDescriptionLength),
+		Timestamp:   chapter.Date.In(getTimezone(server)).Format(time.RFC3339),
+		Author:      &discordgo.MessageEmbedAuthor{Name: truncateText(chapter.Manga, maxEmbedTitleLength)},
+	}
+
+	if target := findTarget(chapter.Manga); target != nil {
+		embed.Author.IconURL = target.Icon
+		if color, err := parsers.ParseColor(target.Color); err == nil {
+			embed.Color = color
+		}
+	}
+	if chapter.Thumbnail != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: chapter.Thumbnail}
+	}
+	if chapter.Author != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   lang.text("embed-author"),
+			Value:  truncateText(chapter.Author, maxEmbedFieldValueLength),
+			Inline: true,
+		})
+	}
+	if chapter.Access != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   lang.text("embed-access"),
+			Value:  lang.text("access-" + chapter.Access),
+			Inline: true,
+		})
+	}
+
+	return embed
+}
+
+// Announce a single chapter to a certain channel, with the guild's template for its series if there's one.
+func announceChapter(session *discordgo.Session, server *types.Server, templates []types.Template, channelId string, chapter *types.Chapter) (*discordgo.Message, error) {
+	message, err := session.ChannelMessageSendComplex(channelId, makeAnnouncement(server, findTemplate(templates, chapter.Manga), chapter))
 	if err != nil {
 		return nil, err
 	}
@@ -29,11 +89,22 @@ func announceChapter(session *discordgo.Session, server *types.Server, chapter *
 	return message, nil
 }
 
-// Mention subscribers for announced chapter.
-func mentionSubscribers(db database.Database, session *discordgo.Session, server *types.Server, chapter *types.Chapter) (*discordgo.Message, error) {
-	userIds, err := db.GetSubscribers(server.Identifier, chapter.Manga)
-	if err != nil {
-		return nil, err
+// Mention subscribers for announced chapter's series.
+// Users subscribed to more than one of the series are only mentioned once.
+func mentionSubscribers(db database.Database, session *discordgo.Session, server *types.Server, channelId string, titles ...string) (*discordgo.Message, error) {
+	var userIds []string
+	picked := make(map[string]bool)
+	for _, title := range titles {
+		subscribers, err := db.GetSubscribers(server.Identifier, title)
+		if err != nil {
+			return nil, err
+		}
+		for _, userId := range subscribers {
+			if !picked[userId] {
+				picked[userId] = true
+				userIds = append(userIds, userId)
+			}
+		}
 	}
 
 	// Collect mention string
@@ -50,7 +121,7 @@ func mentionSubscribers(db database.Database, session *discordgo.Session, server
 	// Send all mention strings in a single message
 	// TODO: Split the message if it's too long?
 	if len(mentions) > 0 {
-		message, err := session.ChannelMessageSend(server.FeedChannelIdentifier, strings.Join(mentions, " "))
+		message, err := session.ChannelMessageSend(channelId, strings.Join(mentions, " "))
 		if err != nil {
 			return nil, err
 		}
@@ -61,11 +132,178 @@ func mentionSubscribers(db database.Database, session *discordgo.Session, server
 	return nil, nil
 }
 
+// Picks the channels a chapter should be announced to.
+// These are the channels routed to the chapter's series or to one of its labels,
+// or the guild's feed channel if there are none.
+func routeChapter(server *types.Server, routes []types.Route, chapter *types.Chapter) []string {
+	var labels []string
+	if target := findTarget(chapter.Manga); target != nil {
+		labels = target.Labels
+	}
+
+	var channelIds []string
+	picked := make(map[string]bool)
+	for _, route := range routes {
+		matches := route.Kind == database.RouteSeries && route.Value == chapter.Manga
+		if route.Kind == database.RouteLabel {
+			for _, label := range labels {
+				if strings.EqualFold(label, route.Value) {
+					matches = true
+				}
+			}
+		}
+
+		if matches && !picked[route.ChannelId] {
+			picked[route.ChannelId] = true
+			channelIds = append(channelIds, route.ChannelId)
+		}
+	}
+
+	if len(channelIds) < 1 {
+		channelIds = append(channelIds, server.FeedChannelIdentifier)
+	}
+
+	return channelIds
+}
+
+// Records that a chapter is about to be delivered to channels, before any of them is sent to,
+// so the chapter stays unannounced for the channels it doesn't reach if the bot stops halfway.
+func markDeliveriesPending(db database.Database, server *types.Server, chapter *types.Chapter, channelIds []string) error {
+	for _, channelId := range channelIds {
+		err := db.SaveDelivery(types.Delivery{
+			GuildId:   server.Identifier,
+			ChannelId: channelId,
+			ChapterId: chapter.Id,
+			Status:    database.DeliveryPending,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Announces a chapter to every channel it's routed to in a guild, records the deliveries, and mentions the subscribers.
+// Every channel is recorded as pending before anything is sent, so the channels not reached yet still get the chapter
+// if the bot stops halfway. Each delivery is recorded right after the announcement is sent, so the chapter is never
+// announced twice to a channel even if something fails afterwards; a failed announcement is recorded too, so it's retried next time.
+// Channels the chapter has already been delivered to are skipped.
+func deliverChapter(db database.Database, session *discordgo.Session, server *types.Server, routes []types.Route, templates []types.Template, chapter *types.Chapter) error {
+	finished, err := db.GetFinishedDeliveryChannels(server.Identifier, chapter.Id)
+	if err != nil {
+		return err
+	}
+	isFinished := make(map[string]bool)
+	for _, channelId := range finished {
+		isFinished[channelId] = true
+	}
+
+	var channelIds []string
+	for _, channelId := range routeChapter(server, routes, chapter) {
+		if !isFinished[channelId] {
+			channelIds = append(channelIds, channelId)
+		}
+	}
+	err = markDeliveriesPending(db, server, chapter, channelIds)
+	if err != nil {
+		return err
+	}
+
+	// Subscribers only need to be mentioned once, in the first channel the chapter gets announced to
+	mentioned := len(finished) > 0
+	var firstErr error
+	for _, channelId := range channelIds {
+		message, err := announceChapter(session, server, templates, channelId, chapter)
+		if err != nil {
+			saveErr := db.SaveDelivery(types.Delivery{
+				GuildId:   server.Identifier,
+				ChannelId: channelId,
+				ChapterId: chapter.Id,
+				Status:    database.DeliveryFailed,
+				Error:     err.Error(),
+			})
+			if saveErr != nil {
+				fmt.Println(helpers.FormattedNow(), server.Identifier+":", saveErr.Error())
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		err = db.SaveDelivery(types.Delivery{
+			GuildId:   server.Identifier,
+			ChannelId: channelId,
+			ChapterId: chapter.Id,
+			Status:    database.DeliveryDelivered,
+			MessageId: message.ID,
+		})
+		if err != nil {
+			fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
+		}
+
+		if !mentioned {
+			_, err = mentionSubscribers(db, session, server, channelId, chapter.Manga)
+			if err != nil {
+				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
+			}
+			mentioned = true
+		}
+	}
+
+	return firstErr
+}
+
+// Announces a guild's unannounced chapters, in digests if the guild gets them, or else one at a time.
+// The announcing lease held by the owner is renewed as the announcement goes on, so long ones don't lose it.
+// One at a time, the announcement stops at the first chapter that fails and logs the last announcement time of the ones before it.
+// Cancelling the context stops it after the chapter (or digest message) being sent, and returns the context's error.
+func announceChapters(ctx context.Context, db database.Database, session *discordgo.Session, server *types.Server, owner string, routes []types.Route, templates []types.Template, chapters []types.Chapter) error {
+	if isDigestMode(server) {
+		return announceDigests(ctx, db, session, server, owner, routes, templates, chapters)
+	}
+
+	var err error
+	announced := false
+	var lastLoggedAt time.Time
+	for _, chapter := range chapters {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+
+		err = deliverChapter(db, session, server, routes, templates, &chapter)
+		if err != nil {
+			break
+		}
+		fmt.Println(helpers.FormattedNow(), "Chapter ["+chapter.Manga+"]:", chapter.Title, "announced for server", server.Identifier)
+
+		lastLoggedAt = chapter.LoggedAt
+		announced = true
+
+		// Keep the lease alive for long announcements
+		db.RenewAnnouncingLease(server.Identifier, owner, announcingLeaseDuration)
+	}
+
+	if announced {
+		logErr := db.SetLastAnnouncedTime(server.Identifier, lastLoggedAt)
+		if logErr != nil && err == nil {
+			err = logErr
+		} else if logErr != nil {
+			fmt.Println(helpers.FormattedNow(), server.Identifier+":", logErr.Error())
+		}
+	}
+
+	return err
+}
+
 // The "mother" announcer process.
 // This gets the list of all registered guilds and their unannounced chapters.
-// If found, it sends the new chapters to the guilds' feed channels,
-// and then logs the last announcement time of each guild.
-func startAnnouncers(db database.Database) error {
+// If found, it sends the new chapters to the guilds' feed channels (or the channels they're routed to),
+// recording the delivery of each chapter, and then logs the last announcement time of each guild.
+// Cancelling the context stops the announcers after the chapter they're currently announcing.
+func startAnnouncers(ctx context.Context, db database.Database) error {
 	// Get the list of servers
 	servers, err := db.GetServers()
 	if err != nil {
@@ -82,73 +320,60 @@ func startAnnouncers(db database.Database) error {
 			fmt.Println(helpers.FormattedNow(), "Starting announcement process for server", server.Identifier)
 
 			var err error = nil
-			// Check if the bot is working on announcing the chapters in this server
-			var isAnnouncing bool
-			isAnnouncing, err = db.GetAnnouncingServerFlag(server.Identifier)
+			// Take the announcing lease; cancel if another announcer is working on this server
+			owner := newLeaseOwner()
+			var acquired bool
+			acquired, err = db.AcquireAnnouncingLease(server.Identifier, owner, announcingLeaseDuration)
 			if err != nil {
 				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
 				waiter.Done()
 				return
 			}
-
-			// Cancel if is announcing
-			if isAnnouncing {
+			if !acquired {
+				fmt.Println(helpers.FormattedNow(), "Another announcer is working on server", server.Identifier)
 				waiter.Done()
 				return
 			}
 
-			// Set the "is announcing" flag to true
-			err = db.SetAnnouncingServerFlag(server.Identifier, true)
+			// Fetch all unannounced chapters, where they should go and how
+			chapters, err := db.GetUnannouncedChapters(server.Identifier)
 			if err != nil {
 				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
+				db.ReleaseAnnouncingLease(server.Identifier, owner)
 				waiter.Done()
 				return
 			}
-
-			// Fetch all unannounced chapters
-			chapters, err := db.GetUnannouncedChapters(server.Identifier)
+			routes, err := db.GetRoutes(server.Identifier)
+			if err != nil {
+				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
+				db.ReleaseAnnouncingLease(server.Identifier, owner)
+				waiter.Done()
+				return
+			}
+			templates, err := db.GetTemplates(server.Identifier)
 			if err != nil {
 				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
-				db.SetAnnouncingServerFlag(server.Identifier, false)
+				db.ReleaseAnnouncingLease(server.Identifier, owner)
 				waiter.Done()
 				return
 			}
 
-			if len(*chapters) > 0 {
-				// Send all the chapters
+			if len(*chapters) > 0 && !isDigestDue(&server, time.Now()) {
+				fmt.Println(helpers.FormattedNow(), "Today's digest has already been sent for server", server.Identifier)
+			} else if len(*chapters) > 0 {
 				fmt.Println(helpers.FormattedNow(), "Announcing new chapters for server", server.Identifier, "...")
-				announced := false
-				var lastLoggedAt time.Time
-				// Loop for each chapter
-				for _, chapter := range *chapters {
-					_, err = announceChapter(session, &server, &chapter)
-					if err != nil {
-						fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
-						break
-					}
-					fmt.Println(helpers.FormattedNow(), "Chapter ["+chapter.Manga+"]:", chapter.Title, "announced for server", server.Identifier)
-
-					mentionSubscribers(db, session, &server, &chapter)
-					if err != nil {
-						fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
-					}
-
-					lastLoggedAt = chapter.LoggedAt
-					announced = true
-				}
-
-				if announced {
-					err = db.SetLastAnnouncedTime(server.Identifier, lastLoggedAt)
-					if err != nil {
-						fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
-					}
+				err = announceChapters(ctx, db, session, &server, owner, routes, templates, *chapters)
+				if ctx.Err() != nil {
+					fmt.Println(helpers.FormattedNow(), "Announcement process cancelled for server", server.Identifier)
+				} else if err != nil {
+					fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
 				}
 			} else {
 				fmt.Println(helpers.FormattedNow(), "No new chapters for server", server.Identifier)
 			}
 
-			// Clear the "is announcing" flag back to false
-			err = db.SetAnnouncingServerFlag(server.Identifier, false)
+			// Give the announcing lease back
+			err = db.ReleaseAnnouncingLease(server.Identifier, owner)
 			if err != nil {
 				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
 			}
@@ -0,0 +1,384 @@
+// This file handles the command line subcommands.
+// Running the program without any argument starts the bot as usual.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hermitpopcorn/decatholac-mango/database"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// Runs the subcommand with the given name and returns the exit code.
+func runSubcommand(name string, args []string) int {
+	switch name {
+	case "migrate":
+		return runMigrateCommand(args)
+	case "validate":
+		return runValidateCommand(args)
+	case "test-target":
+		return runTestTargetCommand(args)
+	case "fixtures":
+		return runFixturesCommand(args)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown command:", name)
+		fmt.Fprintln(os.Stderr, "Available commands: migrate, validate, test-target, fixtures")
+		return 2
+	}
+}
+
+// Handles the "migrate" subcommand.
+// "migrate status" lists every migration and whether it has been applied,
+// "migrate dry-run" prints the statements of pending migrations without running them,
+// and "migrate up" applies the pending migrations.
+// Only the database setting is needed, so the rest of the config doesn't have to be valid.
+func runMigrateCommand(args []string) int {
+	loaded, _, err := decodeConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load "+configFile+":", err.Error())
+		return 1
+	}
+	config = loaded
+
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "status", "dry-run":
+		sqlite, err := database.InspectSQLiteDatabase(getDatabaseFile())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not open the database:", err.Error())
+			return 1
+		}
+		defer sqlite.Close()
+
+		statuses, err := sqlite.GetMigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read the migration status:", err.Error())
+			return 1
+		}
+
+		pending := 0
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("[applied %s] %d: %s\n", status.AppliedAt.Local().Format("2006-01-02 15:04:05"), status.Version, status.Description)
+				continue
+			}
+
+			pending++
+			fmt.Printf("[pending] %d: %s\n", status.Version, status.Description)
+			if action == "dry-run" {
+				for _, statement := range status.Statements {
+					fmt.Println("    " + strings.TrimSpace(statement) + ";")
+				}
+			}
+		}
+		fmt.Println(pending, "pending migration(s)")
+	case "up":
+		sqlite, err := database.OpenSQLiteDatabase(getDatabaseFile())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Migration failed:", err.Error())
+			return 1
+		}
+		defer sqlite.Close()
+
+		version, err := sqlite.GetSchemaVersion()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		fmt.Println("Database is at schema version", version)
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown migrate action:", action)
+		fmt.Fprintln(os.Stderr, "Available actions: status, dry-run, up")
+		return 2
+	}
+
+	return 0
+}
+
+// Handles the "validate" subcommand.
+// It checks the config file (or the file given) and lists every problem found with the line it's on.
+func runValidateCommand(args []string) int {
+	file := configFile
+	if len(args) > 0 {
+		file = args[0]
+	}
+
+	loaded, metadata, err := decodeConfig(file)
+	if err != nil {
+		var parseError toml.ParseError
+		if errors.As(err, &parseError) {
+			fmt.Fprintln(os.Stderr, file+":", parseError.ErrorWithPosition())
+		} else {
+			fmt.Fprintln(os.Stderr, file+":", err.Error())
+		}
+		return 1
+	}
+
+	err = validateConfig(&loaded, metadata)
+	if err == nil {
+		fmt.Println(file, "is valid, with", len(loaded.Targets), "target(s)")
+		return 0
+	}
+
+	var configError *ConfigError
+	if !errors.As(err, &configError) {
+		fmt.Fprintln(os.Stderr, file+":", err.Error())
+		return 1
+	}
+
+	lines, err := readConfigLines(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, file+":", err.Error())
+		return 1
+	}
+	for _, problem := range configError.Problems {
+		message := problem.String()
+		if index := getTargetIndex(problem.Key); index >= 0 && index < len(loaded.Targets) && loaded.Targets[index].Name != "" {
+			message += " (target \"" + loaded.Targets[index].Name + "\")"
+		}
+
+		number := lines.find(problem.Key)
+		if number < 1 {
+			fmt.Println(file + ": " + message)
+			continue
+		}
+		fmt.Printf("%s:%d: %s\n", file, number, message)
+		fmt.Printf("    %4d | %s\n", number, strings.TrimRight(lines.line(number), " \t"))
+	}
+	fmt.Println(len(configError.Problems), "problem(s) found")
+
+	return 1
+}
+
+// Gets the index of the target a key is in, e.g. 1 for "targets[1].keys.chapters", or -1 if it's not in one.
+func getTargetIndex(key string) int {
+	var index int
+	if _, err := fmt.Sscanf(key, "targets[%d]", &index); err != nil {
+		return -1
+	}
+
+	return index
+}
+
+// Handles the "test-target" subcommand.
+// "test-target (name)" fetches and parses a target from the config (or one added with commands) and prints the chapters it finds,
+// and "test-target -definition (toml)" does the same for a target that isn't saved anywhere; "-definition -" reads it from stdin.
+// With "-replay (directory)", the response recorded there is parsed instead of fetching the source.
+// Nothing is written to the database.
+func runTestTargetCommand(args []string) int {
+	flags := flag.NewFlagSet("test-target", flag.ContinueOnError)
+	definition := flags.String("definition", "", "a target as a TOML inline table or a [[targets]] entry, or - to read it from stdin")
+	asJson := flags.Bool("json", false, "print the result as JSON")
+	timeout := flags.Duration("timeout", time.Minute, "how long to wait for the source")
+	replay := flags.String("replay", "", "parse the response recorded in this fixtures directory instead of fetching the source")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// The config is only needed for the [http] settings and the targets in it, so it doesn't have to exist or be valid
+	if loaded, _, err := decodeConfig(configFile); err == nil {
+		config = loaded
+	}
+
+	var target types.Target
+	var err error
+	switch {
+	case *definition != "":
+		text := *definition
+		if text == "-" {
+			input, readErr := io.ReadAll(os.Stdin)
+			if readErr != nil {
+				fmt.Fprintln(os.Stderr, "Could not read the definition:", readErr.Error())
+				return 1
+			}
+			text = string(input)
+		}
+		target, err = decodeTargetDefinition(text)
+	case flags.NArg() == 1:
+		target, err = findTargetForTest(flags.Arg(0))
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: test-target [-json] [-timeout 1m] [-replay (directory)] (name)")
+		fmt.Fprintln(os.Stderr, "       test-target [-json] [-timeout 1m] [-replay (directory)] -definition (toml)")
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	var result targetTestResult
+	if *replay != "" {
+		result = testTargetWithFixture(*replay, &target)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		result = testTarget(ctx, &target)
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else {
+		printTargetTestResult(os.Stdout, result)
+	}
+
+	if result.Error != "" {
+		return 1
+	}
+	return 0
+}
+
+// Finds a target by its name in the config, or in the database if it was added with commands.
+// The database is only read, and isn't migrated.
+func findTargetForTest(name string) (types.Target, error) {
+	for _, target := range getConfig().Targets {
+		if target.Name == name {
+			return target, nil
+		}
+	}
+
+	sqlite, err := database.InspectSQLiteDatabase(getDatabaseFile())
+	if err == nil {
+		defer sqlite.Close()
+
+		entry, err := sqlite.GetTarget(name)
+		if err == nil {
+			return entry.Target, nil
+		}
+	}
+
+	return types.Target{}, errors.New("target not found: " + name)
+}
+
+// Handles the "fixtures" subcommand.
+// "fixtures record" fetches the targets in the config and saves their responses and parsed chapters to the fixtures directory,
+// and "fixtures replay" parses the saved responses again and compares the chapters with the saved ones, without the network.
+// Both work on every target in the config, or only on the ones named after the action.
+// "fixtures replay -update" saves the chapters it parses as the new expected ones instead of comparing them.
+func runFixturesCommand(args []string) int {
+	if len(args) < 1 || (args[0] != "record" && args[0] != "replay") {
+		fmt.Fprintln(os.Stderr, "Usage: fixtures record [-dir fixtures] [-config config.toml] [name...]")
+		fmt.Fprintln(os.Stderr, "       fixtures replay [-dir fixtures] [-config config.toml] [-update] [name...]")
+		return 2
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("fixtures "+action, flag.ContinueOnError)
+	directory := flags.String("dir", "fixtures", "the fixtures directory")
+	file := flags.String("config", configFile, "the config file to read the targets from")
+	update := flags.Bool("update", false, "save the parsed chapters as the expected ones (replay only)")
+	timeout := flags.Duration("timeout", time.Minute, "how long to wait for each source (record only)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	loaded, _, err := decodeConfig(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load "+*file+":", err.Error())
+		return 1
+	}
+	config = loaded
+
+	// Pick the targets to work on
+	targets := loaded.Targets
+	named := flags.NArg() > 0
+	if named {
+		targets = nil
+		for _, name := range flags.Args() {
+			found := false
+			for _, target := range loaded.Targets {
+				if target.Name == name {
+					targets = append(targets, target)
+					found = true
+					break
+				}
+			}
+			if !found {
+				fmt.Fprintln(os.Stderr, "Target not found:", name)
+				return 1
+			}
+		}
+	}
+
+	failed := 0
+	switch action {
+	case "record":
+		err = os.MkdirAll(*directory, 0755)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+
+		for _, target := range targets {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			count, err := recordFixture(ctx, *directory, &target)
+			cancel()
+			if err != nil {
+				fmt.Printf("FAIL %s: %s\n", target.Name, err.Error())
+				failed++
+				continue
+			}
+			fmt.Printf("ok   %s (%d chapter(s))\n", target.Name, count)
+		}
+
+	case "replay":
+		for _, target := range targets {
+			if *update {
+				chapters, err := replayFixture(*directory, &target)
+				if err == nil {
+					_, snapshotPath := getFixturePaths(*directory, target.Name)
+					err = writeFixtureSnapshot(snapshotPath, makeFixtureSnapshot(&target, chapters))
+				}
+				if err != nil {
+					fmt.Printf("FAIL %s: %s\n", target.Name, err.Error())
+					failed++
+					continue
+				}
+				fmt.Printf("ok   %s (%d chapter(s) saved)\n", target.Name, len(chapters))
+				continue
+			}
+
+			differences, err := checkFixture(*directory, &target)
+			if err != nil {
+				var noFixture *NoFixtureError
+				if errors.As(err, &noFixture) && !named {
+					fmt.Printf("skip %s: no fixture\n", target.Name)
+					continue
+				}
+				fmt.Printf("FAIL %s: %s\n", target.Name, err.Error())
+				failed++
+				continue
+			}
+			if len(differences) > 0 {
+				fmt.Printf("FAIL %s\n", target.Name)
+				for _, difference := range differences {
+					fmt.Println("     " + difference)
+				}
+				failed++
+				continue
+			}
+			fmt.Printf("ok   %s\n", target.Name)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Println(failed, "target(s) failed")
+		return 1
+	}
+	return 0
+}
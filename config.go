@@ -0,0 +1,419 @@
+// This file handles the configuration file.
+// The config is read on startup, and read again whenever the file changes or the bot receives SIGHUP.
+// A new config is only applied if it's valid; otherwise the bot keeps running with the old one.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+	"github.com/robfig/cron/v3"
+)
+
+const configFile = "config.toml"
+
+// How often the config file is checked for changes.
+const configWatchInterval = 5 * time.Second
+
+type configuration struct {
+	Database         string
+	Token            string
+	Admins           []string
+	Targets          []types.Target
+	WebInterfacePort string
+	CronInterval     string
+	Alerts           alertConfiguration
+	Fetch            fetchConfiguration
+	Http             types.HttpConfig
+}
+
+var config configuration
+var configLock sync.RWMutex
+
+// Gets the current config.
+// The config is replaced as a whole on reload and never modified in place, so the copy is safe to keep using.
+func getConfig() configuration {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return config
+}
+
+// Reads the config on startup, and exits if it can't be read or is invalid.
+func loadConfig() {
+	loaded, err := readConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load "+configFile+":")
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	configLock.Lock()
+	config = loaded
+	configLock.Unlock()
+}
+
+// Reads and validates a config file.
+func readConfig(file string) (configuration, error) {
+	loaded, metadata, err := decodeConfig(file)
+	if err != nil {
+		return loaded, err
+	}
+
+	return loaded, validateConfig(&loaded, metadata)
+}
+
+// Reads a config file without validating it.
+func decodeConfig(file string) (configuration, toml.MetaData, error) {
+	var loaded configuration
+
+	if _, err := os.Stat(file); err != nil {
+		return loaded, toml.MetaData{}, errors.New("config file not found")
+	}
+
+	metadata, err := toml.DecodeFile(file, &loaded)
+	return loaded, metadata, err
+}
+
+// A problem found in a config. Key is where it is, e.g. "targets[1].keys.chapters".
+type configProblem struct {
+	Key     string
+	Message string
+}
+
+func (p configProblem) String() string {
+	return p.Key + ": " + p.Message
+}
+
+// This error lists every problem found in a config.
+type ConfigError struct {
+	Problems []configProblem
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Checks the config for values that would only fail later, e.g. when the cronjob or a gofer runs,
+// and for keys that aren't used at all, which are usually typos.
+func validateConfig(c *configuration, metadata toml.MetaData) error {
+	var problems []configProblem
+
+	for _, key := range metadata.Undecoded() {
+		problems = append(problems, configProblem{Key: key.String(), Message: "unknown key"})
+	}
+
+	if _, err := cron.ParseStandard(toCronSpec(c.CronInterval)); err != nil {
+		problems = append(problems, configProblem{Key: "cronInterval", Message: fmt.Sprintf("invalid interval %q", c.CronInterval)})
+	}
+
+	for _, value := range []struct{ key, duration string }{
+		{"fetch.hostSpacing", c.Fetch.HostSpacing},
+		{"fetch.backoffBase", c.Fetch.BackoffBase},
+		{"fetch.backoffMax", c.Fetch.BackoffMax},
+	} {
+		if value.duration == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value.duration); err != nil || duration < 0 {
+			problems = append(problems, configProblem{Key: value.key, Message: fmt.Sprintf("invalid duration %q", value.duration)})
+		}
+	}
+
+	problems = append(problems, validateHttpConfig("http", c.Http)...)
+
+	names := make(map[string]int)
+	for index, target := range c.Targets {
+		key := fmt.Sprintf("targets[%d]", index)
+
+		for _, problem := range getTargetProblems(&target) {
+			problems = append(problems, configProblem{Key: key + "." + problem.Field, Message: problem.Message})
+		}
+		if other, exists := names[target.Name]; exists && target.Name != "" {
+			problems = append(problems, configProblem{Key: key + ".name", Message: fmt.Sprintf("%q is already the name of targets[%d]", target.Name, other)})
+		} else {
+			names[target.Name] = index
+		}
+
+		problems = append(problems, validateHttpConfig(key+".http", target.Http)...)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+// Checks the HTTP settings without building a client.
+func validateHttpConfig(key string, settings types.HttpConfig) []configProblem {
+	var problems []configProblem
+
+	if settings.Timeout != "" {
+		if _, err := time.ParseDuration(settings.Timeout); err != nil {
+			problems = append(problems, configProblem{Key: key + ".timeout", Message: fmt.Sprintf("invalid duration %q", settings.Timeout)})
+		}
+	}
+	if settings.Proxy != "" {
+		if _, err := url.Parse(settings.Proxy); err != nil {
+			problems = append(problems, configProblem{Key: key + ".proxy", Message: fmt.Sprintf("invalid URL %q", settings.Proxy)})
+		}
+	}
+	if settings.MinTlsVersion != "" {
+		if _, err := parseTlsVersion(settings.MinTlsVersion); err != nil {
+			problems = append(problems, configProblem{Key: key + ".minTlsVersion", Message: err.Error()})
+		}
+	}
+	if settings.CookieFile != "" {
+		if _, err := os.Stat(settings.CookieFile); err != nil {
+			problems = append(problems, configProblem{Key: key + ".cookieFile", Message: fmt.Sprintf("file %q not found", settings.CookieFile)})
+		}
+	}
+
+	return problems
+}
+
+var reloadLock sync.Mutex
+
+// Reads the config file again and applies it: the targets are seeded and rescheduled,
+// and the web interface is restarted if its port changed.
+// If the new config is invalid, the problems are reported as an alert and the old config is kept.
+// The database and the token can't be changed without a restart.
+func reloadConfig() {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	loaded, err := readConfig(configFile)
+	if err != nil {
+		sendAlert("The config file was not reloaded because it has problems:\n" + err.Error())
+		return
+	}
+
+	old := getConfig()
+	if loaded.Database != old.Database || loaded.Token != old.Token {
+		fmt.Println(helpers.FormattedNow(), "The database and token settings only take effect after a restart")
+		loaded.Database = old.Database
+		loaded.Token = old.Token
+	}
+
+	configLock.Lock()
+	config = loaded
+	configLock.Unlock()
+
+	if loaded.Fetch.HostConcurrency != old.Fetch.HostConcurrency {
+		resetHostLimiters()
+	}
+
+	err = db.SeedTargets(loaded.Targets)
+	if err == nil {
+		err = reloadTargets()
+	}
+	if err != nil {
+		sendAlert("The targets from the reloaded config could not be applied: " + err.Error())
+	}
+
+	if getWebInterfacePort(loaded) != getWebInterfacePort(old) {
+		restartWebInterface()
+	}
+
+	fmt.Println(helpers.FormattedNow(), "Reloaded "+configFile)
+}
+
+// Checks the config file every now and then, and reloads it when it has changed.
+func watchConfig(ctx context.Context) {
+	lastModified := getConfigModifiedTime()
+
+	for sleepContext(ctx, configWatchInterval) {
+		modified := getConfigModifiedTime()
+		if modified.Equal(lastModified) {
+			continue
+		}
+		lastModified = modified
+
+		fmt.Println(helpers.FormattedNow(), configFile, "has changed, reloading...")
+		reloadConfig()
+	}
+}
+
+func getConfigModifiedTime() time.Time {
+	info, err := os.Stat(configFile)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// Gets the database file path from the config, or the default one if it's not set.
+func getDatabaseFile() string {
+	databaseFile := getConfig().Database
+	if databaseFile == "" {
+		databaseFile = "database.db"
+	}
+
+	return databaseFile
+}
+
+// Knows which line of a config file each key is on, so problems can be shown where they are.
+// Keys of array tables are numbered like the problems are, e.g. "targets[1].keys.chapters".
+type configLines struct {
+	lines   []string
+	keys    map[string]int // Key to line number, starting at 1
+	order   []string       // The keys in the order they appear
+	claimed map[string]bool
+}
+
+var arrayIndexPattern = regexp.MustCompile(`\[\d+\]`)
+
+// Reads a config file and finds the line of each table and key in it.
+// This only understands the config as far as the keys go; values spanning several lines are skipped over.
+func readConfigLines(file string) (*configLines, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &configLines{
+		lines:   strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n"),
+		keys:    make(map[string]int),
+		claimed: make(map[string]bool),
+	}
+	record := func(key string, line int) {
+		if _, exists := c.keys[key]; !exists {
+			c.keys[key] = line
+			c.order = append(c.order, key)
+		}
+	}
+
+	arrayCounts := make(map[string]int)
+	table := ""
+	for index, line := range c.lines {
+		number := index + 1
+		trimmed := strings.TrimSpace(stripTomlComment(line))
+
+		switch {
+		case strings.HasPrefix(trimmed, "[[") && strings.HasSuffix(trimmed, "]]"):
+			name := normalizeTomlKey(strings.TrimSuffix(strings.TrimPrefix(trimmed, "[["), "]]"))
+			name = indexTomlKey(name, arrayCounts, true)
+			arrayCounts[name]++
+			table = fmt.Sprintf("%s[%d]", name, arrayCounts[name]-1)
+			record(table, number)
+
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			table = indexTomlKey(normalizeTomlKey(strings.TrimSuffix(strings.TrimPrefix(trimmed, "["), "]")), arrayCounts, false)
+			record(table, number)
+
+		case strings.Contains(trimmed, "="):
+			key := normalizeTomlKey(trimmed[:strings.Index(trimmed, "=")])
+			if table != "" {
+				key = table + "." + key
+			}
+			record(key, number)
+		}
+	}
+
+	return c, nil
+}
+
+// Finds the line of a key, or of the closest table containing it. Returns 0 if it's not found.
+// Keys without array numbers (like the ones of unknown keys) are matched to their occurrences in order.
+func (c *configLines) find(key string) int {
+	if !strings.Contains(key, "[") {
+		for _, candidate := range c.order {
+			if !c.claimed[candidate] && arrayIndexPattern.ReplaceAllString(candidate, "") == key {
+				c.claimed[candidate] = true
+				return c.keys[candidate]
+			}
+		}
+	}
+
+	for key != "" {
+		if line, exists := c.keys[key]; exists {
+			return line
+		}
+
+		cut := strings.LastIndexAny(key, ".[")
+		if cut < 0 {
+			break
+		}
+		key = key[:cut]
+	}
+
+	return 0
+}
+
+// Gets the text of a line, starting at 1.
+func (c *configLines) line(number int) string {
+	if number < 1 || number > len(c.lines) {
+		return ""
+	}
+
+	return c.lines[number-1]
+}
+
+// Removes a comment from a line, leaving "#" inside strings alone.
+func stripTomlComment(line string) string {
+	var quote rune
+	for index, char := range line {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\''):
+			quote = char
+		case quote == 0 && char == '#':
+			return line[:index]
+		}
+	}
+
+	return line
+}
+
+// Turns a key as written in the file (e.g. ` targets . "keys" `) into a plain dotted key.
+func normalizeTomlKey(key string) string {
+	parts := strings.Split(key, ".")
+	for index, part := range parts {
+		parts[index] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Numbers the array tables in a table name, e.g. "targets.keys" becomes "targets[1].keys" inside the second [[targets]].
+// The name itself isn't numbered if it's a new array table.
+func indexTomlKey(name string, arrayCounts map[string]int, isArray bool) string {
+	parts := strings.Split(name, ".")
+	end := len(parts)
+	if isArray {
+		end--
+	}
+
+	for i := end; i > 0; i-- {
+		prefix := strings.Join(parts[:i], ".")
+		if count := arrayCounts[prefix]; count > 0 {
+			rest := strings.Join(parts[i:], ".")
+			indexed := fmt.Sprintf("%s[%d]", prefix, count-1)
+			if rest != "" {
+				indexed += "." + rest
+			}
+			return indexed
+		}
+	}
+
+	return name
+}
@@ -34,19 +34,128 @@ func (e *NoSubscriptionFoundError) Error() string {
 	return "The user is not subscribed to such title."
 }
 
+// This error is thrown whenever a guild requests to unfollow a title it does not follow.
+type NotFollowingError struct{}
+
+func (e *NotFollowingError) Error() string {
+	return "The server is not following such title."
+}
+
+// The statuses of a chapter's delivery to a guild.
+// A delivery is pending from just before the chapter is sent until it's known to be delivered or failed,
+// so a chapter still counts as unannounced for the channels it hadn't reached when the bot stopped.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliverySkipped   = "skipped"
+)
+
+// This error is thrown whenever a target is requested by a name that does not exist.
+type TargetDoesNotExistError struct{}
+
+func (e *TargetDoesNotExistError) Error() string {
+	return "No target with such name exists."
+}
+
+// This error is thrown whenever a target is added with a name that's already taken.
+type TargetAlreadyExistsError struct{}
+
+func (e *TargetAlreadyExistsError) Error() string {
+	return "A target with that name already exists."
+}
+
+// This error is thrown whenever a target is edited with a different name.
+// Targets can't be renamed, because their chapters, follows, routes, subscriptions and templates go by their name.
+type TargetRenameError struct{}
+
+func (e *TargetRenameError) Error() string {
+	return "A target can't be renamed."
+}
+
+// Where a stored target came from.
+// A removed target is kept as a disabled row, so seeding from the config doesn't bring it back.
+const (
+	TargetFromConfig  = "config"
+	TargetFromDiscord = "discord"
+	TargetRemoved     = "removed"
+)
+
+// The kinds of routes.
+const (
+	RouteSeries = "series"
+	RouteLabel  = "label"
+)
+
+// This error is thrown whenever a guild requests removal of a route that does not exist.
+type NoRouteFoundError struct{}
+
+func (e *NoRouteFoundError) Error() string {
+	return "No such route exists in the server."
+}
+
+// How a guild's chapters are announced: one at a time (the default), grouped in a message per series,
+// or all together once a day.
+const (
+	DigestOff    = "off"
+	DigestSeries = "series"
+	DigestDaily  = "daily"
+)
+
+// This error is thrown whenever a guild requests removal of a template it has not set.
+type NoTemplateFoundError struct{}
+
+func (e *NoTemplateFoundError) Error() string {
+	return "No such template is set in the server."
+}
+
+// A chapter that failed to be delivered this many times is not retried anymore.
+const MaxDeliveryAttempts = 5
+
 type Database interface {
 	GetServers() ([]types.Server, error)
+	GetServer(guildId string) (types.Server, error)
+	SetServerTimezone(guildId string, timezone string) error
+	SetServerLanguage(guildId string, language string) error
+	SetServerDigestMode(guildId string, mode string) error
+	SetLastDigestTime(guildId string, lastDigestAt time.Time) error
 	GetFeedChannel(guildId string) (string, error)
 	SetFeedChannel(guildId string, channelId string) error
 	GetLastAnnouncedTime(guildId string) (time.Time, error)
 	SetLastAnnouncedTime(guildId string, lastAnnouncedAt time.Time) error
 	CheckMangaExistence(title string) (bool, error)
-	SaveChapters(chapters *[]types.Chapter) error
+	SaveChapters(chapters *[]types.Chapter) (int, error)
 	GetUnannouncedChapters(guildId string) (*[]types.Chapter, error)
-	GetAnnouncingServerFlag(guildId string) (bool, error)
-	SetAnnouncingServerFlag(guildId string, announcing bool) error
+	SaveDelivery(delivery types.Delivery) error
+	GetFinishedDeliveryChannels(guildId string, chapterId int64) ([]string, error)
+	AddRoute(route types.Route) error
+	RemoveRoute(route types.Route) error
+	GetRoutes(guildId string) ([]types.Route, error)
+	SetTemplate(template types.Template) error
+	RemoveTemplate(guildId string, series string) error
+	GetTemplates(guildId string) ([]types.Template, error)
+	FollowManga(guildId string, title string) error
+	UnfollowManga(guildId string, title string) error
+	GetFollowedManga(guildId string) ([]string, error)
+	AcquireAnnouncingLease(guildId string, owner string, duration time.Duration) (bool, error)
+	RenewAnnouncingLease(guildId string, owner string, duration time.Duration) error
+	ReleaseAnnouncingLease(guildId string, owner string) error
+	ClearAnnouncingLease(guildId string) error
+	ClearAllAnnouncingLeases() (int64, error)
 	GetSubscribers(guildId string, title string) ([]string, error)
 	SaveSubscription(userId string, guildId string, title string) error
 	RemoveSubscription(userId string, guildId string, title string) error
+	GetFetchCache(target string) (types.FetchCache, error)
+	SetFetchCache(cache types.FetchCache) error
+	SaveFetchRun(run types.FetchRun) error
+	GetFetchRuns(target string, limit int) ([]types.FetchRun, error)
+	GetLatestFetchRuns() ([]types.FetchRun, error)
+	SeedTargets(targets []types.Target) error
+	GetTargets() ([]types.TargetEntry, error)
+	GetTarget(name string) (types.TargetEntry, error)
+	AddTarget(target types.Target) error
+	UpdateTarget(name string, target types.Target) error
+	SetTargetEnabled(name string, enabled bool) error
+	RemoveTarget(name string) error
 	Close() error
 }
@@ -0,0 +1,379 @@
+// This file handles the versioned schema migrations of the SQLite database.
+// Every change to the schema must be appended to the migrations list below
+// (never edit or reorder a migration that has already been released),
+// so existing database files can be upgraded without editing them by hand.
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+)
+
+// A single schema change.
+// The statements are run in order inside one transaction.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// The ordered list of migrations. Versions must be sequential, starting from 1.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "Create the Chapters, Servers and Subscriptions tables",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS 'Chapters' (
+				'id'		INTEGER,
+				'manga'		VARCHAR(255) NOT NULL,
+				'title'		VARCHAR(255) NOT NULL,
+				'number'	VARCHAR(255) NOT NULL,
+				'url'		VARCHAR(255) NOT NULL,
+				'date'		DATETIME,
+				'loggedAt'	DATETIME NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT)
+			)`,
+			`CREATE TABLE IF NOT EXISTS 'Servers' (
+				'id'				INTEGER,
+				'guildId'			VARCHAR(255) NOT NULL,
+				'channelId'			VARCHAR(255),
+				'lastAnnouncedAt'	DATETIME,
+				'isAnnouncing'		INTEGER DEFAULT 0,
+				PRIMARY KEY('id' AUTOINCREMENT)
+			)`,
+			`CREATE TABLE IF NOT EXISTS 'Subscriptions' (
+				'id'				INTEGER,
+				'guildId'			VARCHAR(255) NOT NULL,
+				'userId'			VARCHAR(255) NOT NULL,
+				'title'				VARCHAR(255) NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT)
+			)`,
+		},
+	},
+	{
+		version:     2,
+		description: "Create the FetchCaches table for conditional requests",
+		statements: []string{
+			`CREATE TABLE 'FetchCaches' (
+				'target'		VARCHAR(255) NOT NULL,
+				'etag'			VARCHAR(255),
+				'lastModified'	VARCHAR(255),
+				'updatedAt'		DATETIME NOT NULL,
+				PRIMARY KEY('target')
+			)`,
+		},
+	},
+	{
+		version:     3,
+		description: "Create the FetchRuns table for fetch health tracking",
+		statements: []string{
+			`CREATE TABLE 'FetchRuns' (
+				'id'				INTEGER,
+				'target'			VARCHAR(255) NOT NULL,
+				'startedAt'			DATETIME NOT NULL,
+				'finishedAt'		DATETIME NOT NULL,
+				'httpStatus'		INTEGER NOT NULL DEFAULT 0,
+				'error'				TEXT,
+				'chaptersParsed'	INTEGER NOT NULL DEFAULT 0,
+				'chaptersInserted'	INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY('id' AUTOINCREMENT)
+			)`,
+			`CREATE INDEX 'FetchRunsByTarget' ON 'FetchRuns' ('target', 'startedAt')`,
+		},
+	},
+	{
+		version:     4,
+		description: "Replace the isAnnouncing flag of Servers with an expiring lease",
+		statements: []string{
+			`ALTER TABLE 'Servers' ADD COLUMN 'announcingOwner' VARCHAR(255)`,
+			`ALTER TABLE 'Servers' ADD COLUMN 'announcingUntil' DATETIME`,
+			`ALTER TABLE 'Servers' DROP COLUMN 'isAnnouncing'`,
+		},
+	},
+	{
+		version:     5,
+		description: "Track announcements per chapter with the Deliveries table",
+		statements: []string{
+			`ALTER TABLE 'Chapters' ADD COLUMN 'isBackfill' INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE 'Servers' ADD COLUMN 'announceFrom' DATETIME`,
+			`UPDATE Servers SET announceFrom = lastAnnouncedAt`,
+			`CREATE TABLE 'Deliveries' (
+				'id'			INTEGER,
+				'guildId'		VARCHAR(255) NOT NULL,
+				'chapterId'		INTEGER NOT NULL,
+				'status'		VARCHAR(16) NOT NULL,
+				'messageId'		VARCHAR(255),
+				'attempts'		INTEGER NOT NULL DEFAULT 0,
+				'error'			TEXT,
+				'updatedAt'		DATETIME NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT),
+				UNIQUE('guildId', 'chapterId')
+			)`,
+			// Chapters the old lastAnnouncedAt logic had decided never to announce (old, but logged recently)
+			// are marked as skipped, so upgrading doesn't suddenly announce them
+			`INSERT INTO 'Deliveries' ('guildId', 'chapterId', 'status', 'updatedAt')
+				SELECT s.guildId, c.id, 'skipped', datetime('now')
+				FROM Servers s, Chapters c
+				WHERE c.loggedAt > s.lastAnnouncedAt AND c.date <= s.lastAnnouncedAt`,
+		},
+	},
+	{
+		version:     6,
+		description: "Create the Follows table for per-guild series selection",
+		statements: []string{
+			`CREATE TABLE 'Follows' (
+				'id'		INTEGER,
+				'guildId'	VARCHAR(255) NOT NULL,
+				'manga'		VARCHAR(255) NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT),
+				UNIQUE('guildId', 'manga')
+			)`,
+		},
+	},
+	{
+		version:     7,
+		description: "Create the Routes table and track deliveries per channel",
+		statements: []string{
+			`CREATE TABLE 'Routes' (
+				'id'		INTEGER,
+				'guildId'	VARCHAR(255) NOT NULL,
+				'channelId'	VARCHAR(255) NOT NULL,
+				'kind'		VARCHAR(16) NOT NULL,
+				'value'		VARCHAR(255) NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT),
+				UNIQUE('guildId', 'channelId', 'kind', 'value')
+			)`,
+			`CREATE TABLE 'DeliveriesPerChannel' (
+				'id'			INTEGER,
+				'guildId'		VARCHAR(255) NOT NULL,
+				'channelId'		VARCHAR(255) NOT NULL,
+				'chapterId'		INTEGER NOT NULL,
+				'status'		VARCHAR(16) NOT NULL,
+				'messageId'		VARCHAR(255),
+				'attempts'		INTEGER NOT NULL DEFAULT 0,
+				'error'			TEXT,
+				'updatedAt'		DATETIME NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT),
+				UNIQUE('guildId', 'chapterId', 'channelId')
+			)`,
+			// Existing deliveries all went to the guild's feed channel
+			`INSERT INTO DeliveriesPerChannel (guildId, channelId, chapterId, status, messageId, attempts, error, updatedAt)
+				SELECT d.guildId, COALESCE(s.channelId, ''), d.chapterId, d.status, d.messageId, d.attempts, d.error, d.updatedAt
+				FROM Deliveries d LEFT JOIN Servers s ON s.guildId = d.guildId`,
+			`DROP TABLE 'Deliveries'`,
+			`ALTER TABLE 'DeliveriesPerChannel' RENAME TO 'Deliveries'`,
+		},
+	},
+	{
+		version:     8,
+		description: "Create the Targets table for managing targets from Discord",
+		statements: []string{
+			`CREATE TABLE 'Targets' (
+				'id'			INTEGER,
+				'name'			VARCHAR(255) NOT NULL,
+				'definition'	TEXT NOT NULL,
+				'enabled'		INTEGER NOT NULL DEFAULT 1,
+				'origin'		VARCHAR(16) NOT NULL,
+				'updatedAt'		DATETIME NOT NULL,
+				PRIMARY KEY('id' AUTOINCREMENT),
+				UNIQUE('name')
+			)`,
+		},
+	},
+	{
+		version:     9,
+		description: "Add the timezone and language settings of Servers",
+		statements: []string{
+			`ALTER TABLE 'Servers' ADD COLUMN 'timezone' VARCHAR(64) NOT NULL DEFAULT ''`,
+			`ALTER TABLE 'Servers' ADD COLUMN 'language' VARCHAR(16) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     10,
+		description: "Add the thumbnail, description, author and access of Chapters",
+		statements: []string{
+			`ALTER TABLE 'Chapters' ADD COLUMN 'thumbnail' TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE 'Chapters' ADD COLUMN 'description' TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE 'Chapters' ADD COLUMN 'author' VARCHAR(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE 'Chapters' ADD COLUMN 'access' VARCHAR(16) NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     11,
+		description: "Create the Templates table for the announcement templates of Servers",
+		statements: []string{
+			`CREATE TABLE 'Templates' (
+				'id'		INTEGER,
+				'guildId'	VARCHAR(255) NOT NULL,
+				'series'	VARCHAR(255) NOT NULL DEFAULT '',
+				'template'	TEXT NOT NULL,
+				'embed'		INTEGER NOT NULL DEFAULT 1,
+				PRIMARY KEY('id' AUTOINCREMENT),
+				UNIQUE('guildId', 'series')
+			)`,
+		},
+	},
+	{
+		version:     12,
+		description: "Add the digest mode of Servers",
+		statements: []string{
+			`ALTER TABLE 'Servers' ADD COLUMN 'digestMode' VARCHAR(16) NOT NULL DEFAULT ''`,
+			`ALTER TABLE 'Servers' ADD COLUMN 'lastDigestAt' DATETIME`,
+		},
+	},
+	{
+		version:     13,
+		description: "Add the number of chapters FetchRuns found before the targets' filters",
+		statements: []string{
+			`ALTER TABLE 'FetchRuns' ADD COLUMN 'chaptersFound' INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE 'FetchRuns' SET chaptersFound = chaptersParsed`,
+		},
+	},
+}
+
+// Describes whether a migration has been applied to the database or not.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Statements  []string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// Creates the table that keeps track of applied migrations.
+func (db *SQLiteDatabase) createSchemaVersionTable() error {
+	_, err := db.connection.Exec(`CREATE TABLE IF NOT EXISTS 'schema_version' (
+		'version'		INTEGER NOT NULL,
+		'description'	VARCHAR(255) NOT NULL,
+		'appliedAt'		DATETIME NOT NULL,
+		PRIMARY KEY('version')
+	)`)
+	return err
+}
+
+// Gets the applied migration versions and when they were applied.
+// A database without the schema_version table is treated as an empty (version 0) database.
+func (db *SQLiteDatabase) getAppliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	check := db.connection.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'")
+	var name string
+	err := check.Scan(&name)
+	if err == sql.ErrNoRows {
+		return applied, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.connection.Query("SELECT version, appliedAt FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// Gets the status of every known migration, in order.
+// This does not write anything to the database, so it can be used as a dry run.
+func (db *SQLiteDatabase) GetMigrationStatus() ([]MigrationStatus, error) {
+	applied, err := db.getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.version]
+		statuses = append(statuses, MigrationStatus{
+			Version:     m.version,
+			Description: m.description,
+			Statements:  m.statements,
+			Applied:     ok,
+			AppliedAt:   appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Gets the highest applied migration version.
+func (db *SQLiteDatabase) GetSchemaVersion() (int, error) {
+	applied, err := db.getAppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// Applies every pending migration in order.
+// Each migration runs inside its own transaction together with its schema_version entry,
+// so a failing migration leaves the database at the previous version.
+func (db *SQLiteDatabase) Migrate() error {
+	if err := db.createSchemaVersionTable(); err != nil {
+		return err
+	}
+
+	applied, err := db.getAppliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		fmt.Println(helpers.FormattedNow(), "Applying database migration", m.version, "-", m.description)
+		if err := db.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d failed: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// Runs a single migration inside a transaction.
+func (db *SQLiteDatabase) applyMigration(m migration) error {
+	tx, err := db.connection.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version (version, description, appliedAt) VALUES (?, ?, ?)", m.version, m.description, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Creates a database file the way the bot did before migrations, with a registered guild,
+// its chapters and a subscription, and returns the guild's lastAnnouncedAt.
+func createBaselineDatabase(t *testing.T, file string) time.Time {
+	t.Helper()
+
+	connection, err := sql.Open("sqlite", "file:"+file)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer connection.Close()
+
+	lastAnnouncedAt := time.Now().Add(-time.Hour).UTC()
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{query: `CREATE TABLE 'Chapters' (
+			'id'		INTEGER,
+			'manga'		VARCHAR(255) NOT NULL,
+			'title'		VARCHAR(255) NOT NULL,
+			'number'	VARCHAR(255) NOT NULL,
+			'url'		VARCHAR(255) NOT NULL,
+			'date'		DATETIME,
+			'loggedAt'	DATETIME NOT NULL,
+			PRIMARY KEY('id' AUTOINCREMENT)
+		)`},
+		{query: `CREATE TABLE 'Servers' (
+			'id'				INTEGER,
+			'guildId'			VARCHAR(255) NOT NULL,
+			'channelId'			VARCHAR(255),
+			'lastAnnouncedAt'	DATETIME,
+			'isAnnouncing'		INTEGER DEFAULT 0,
+			PRIMARY KEY('id' AUTOINCREMENT)
+		)`},
+		{query: `CREATE TABLE 'Subscriptions' (
+			'id'				INTEGER,
+			'guildId'			VARCHAR(255) NOT NULL,
+			'userId'			VARCHAR(255) NOT NULL,
+			'title'				VARCHAR(255) NOT NULL,
+			PRIMARY KEY('id' AUTOINCREMENT)
+		)`},
+		// The guild was left stuck announcing
+		{
+			query: "INSERT INTO Servers (guildId, channelId, lastAnnouncedAt, isAnnouncing) VALUES (?, ?, ?, 1)",
+			args:  []any{"guild", "feed", lastAnnouncedAt},
+		},
+		{
+			query: "INSERT INTO Subscriptions (guildId, userId, title) VALUES (?, ?, ?)",
+			args:  []any{"guild", "user", "Alpha"},
+		},
+		// Announced already
+		{
+			query: "INSERT INTO Chapters (manga, title, number, url, date, loggedAt) VALUES (?, ?, ?, ?, ?, ?)",
+			args:  []any{"Alpha", "Alpha 1", "1", "https://comic.com/Alpha/1", lastAnnouncedAt.Add(-time.Hour), lastAnnouncedAt.Add(-time.Hour)},
+		},
+		// Logged after the last announcement, but dated before it, so the old logic never announced it
+		{
+			query: "INSERT INTO Chapters (manga, title, number, url, date, loggedAt) VALUES (?, ?, ?, ?, ?, ?)",
+			args:  []any{"Alpha", "Alpha 2", "2", "https://comic.com/Alpha/2", lastAnnouncedAt.Add(-time.Hour), lastAnnouncedAt.Add(time.Minute)},
+		},
+		// Not announced yet
+		{
+			query: "INSERT INTO Chapters (manga, title, number, url, date, loggedAt) VALUES (?, ?, ?, ?, ?, ?)",
+			args:  []any{"Alpha", "Alpha 3", "3", "https://comic.com/Alpha/3", lastAnnouncedAt.Add(time.Minute), lastAnnouncedAt.Add(time.Minute)},
+		},
+	}
+	for _, statement := range statements {
+		_, err = connection.Exec(statement.query, statement.args...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	return lastAnnouncedAt
+}
+
+func TestMigratingBaselineDatabase(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "baseline.db")
+	lastAnnouncedAt := createBaselineDatabase(t, file)
+
+	db, err := OpenSQLiteDatabase(file)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+
+	statuses, err := db.GetMigrationStatus()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatal("Expected", len(migrations), "migrations, but found", len(statuses))
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Error("Expected migration", status.Version, "to be applied")
+		}
+	}
+
+	// The guild keeps its settings, and only gets what the old logic hadn't announced yet
+	channelId, err := db.GetFeedChannel("guild")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if channelId != "feed" {
+		t.Error("Expected the feed channel to be kept, but found", channelId)
+	}
+	expectUnannounced(t, db, "guild", "Alpha 3")
+
+	server, err := db.GetServer("guild")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !server.LastAnnouncedAt.Equal(lastAnnouncedAt) {
+		t.Error("Expected the last announced time to be kept, but found", server.LastAnnouncedAt)
+	}
+
+	subscribers, err := db.GetSubscribers("guild", "Alpha")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(subscribers) != 1 || subscribers[0] != "user" {
+		t.Error("Expected the subscription to be kept, but found", subscribers)
+	}
+
+	// The isAnnouncing flag is gone, so the guild isn't stuck anymore
+	acquired, err := db.AcquireAnnouncingLease("guild", "owner", time.Minute)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !acquired {
+		t.Error("Expected the announcing lease to be free after migrating")
+	}
+
+	// Opening it again doesn't apply anything twice
+	err = db.Migrate()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -5,8 +5,10 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/hermitpopcorn/decatholac-mango/helpers"
@@ -18,8 +20,31 @@ type SQLiteDatabase struct {
 	connection *sql.DB
 }
 
-// Opens a local SQLite database.
+// Opens a local SQLite database and applies any pending schema migrations.
 func OpenSQLiteDatabase(file string) (*SQLiteDatabase, error) {
+	db, err := openSQLiteConnection(file)
+	if err != nil {
+		return db, err
+	}
+
+	if err := db.InitializeDatabase(); err != nil {
+		return db, err
+	}
+
+	return db, nil
+}
+
+// Opens a local SQLite database without touching its schema.
+// Used for inspecting the migration status of a database file.
+func InspectSQLiteDatabase(file string) (*SQLiteDatabase, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+
+	return openSQLiteConnection(file)
+}
+
+func openSQLiteConnection(file string) (*SQLiteDatabase, error) {
 	var db SQLiteDatabase
 
 	if file == "" {
@@ -41,10 +66,6 @@ func OpenSQLiteDatabase(file string) (*SQLiteDatabase, error) {
 
 	db.connection = connection
 
-	if err := db.InitializeDatabase(); err != nil {
-		return &db, err
-	}
-
 	// Prevent lock-up by "wrapping mutex around every DB access"
 	// https://github.com/mattn/go-sqlite3/issues/274#issuecomment-191597862
 	db.connection.SetMaxOpenConns(1)
@@ -57,58 +78,9 @@ func (db *SQLiteDatabase) Close() error {
 }
 
 // Initializes the database.
-// This creates the neccessary tables if they don't exist yet.
+// This creates the neccessary tables, or upgrades them, by applying pending migrations.
 func (db *SQLiteDatabase) InitializeDatabase() error {
-	check := db.connection.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'Chapters'")
-	err := check.Scan()
-	if err == sql.ErrNoRows {
-		_, err := db.connection.Exec(`CREATE TABLE 'Chapters' (
-			'id'		INTEGER,
-			'manga'		VARCHAR(255) NOT NULL,
-			'title'		VARCHAR(255) NOT NULL,
-			'number'	VARCHAR(255) NOT NULL,
-			'url'		VARCHAR(255) NOT NULL,
-			'date'		DATETIME,
-			'loggedAt'	DATETIME NOT NULL,
-			PRIMARY KEY('id' AUTOINCREMENT)
-		)`)
-		if err != nil {
-			return err
-		}
-	}
-
-	check = db.connection.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'Servers'")
-	err = check.Scan()
-	if err == sql.ErrNoRows {
-		_, err := db.connection.Exec(`CREATE TABLE 'Servers' (
-			'id'				INTEGER,
-			'guildId'			VARCHAR(255) NOT NULL,
-			'channelId'			VARCHAR(255),
-			'lastAnnouncedAt'	DATETIME,
-			'isAnnouncing'		INTEGER DEFAULT 0,
-			PRIMARY KEY('id' AUTOINCREMENT)
-		)`)
-		if err != nil {
-			return err
-		}
-	}
-
-	check = db.connection.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'Subscriptions'")
-	err = check.Scan()
-	if err == sql.ErrNoRows {
-		_, err := db.connection.Exec(`CREATE TABLE 'Subscriptions' (
-			'id'				INTEGER,
-			'guildId'			VARCHAR(255) NOT NULL,
-			'userId'			VARCHAR(255) NOT NULL,
-			'title'				VARCHAR(255) NOT NULL,
-			PRIMARY KEY('id' AUTOINCREMENT)
-		)`)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return db.Migrate()
 }
 
 // Pairs a channel ID to a guild ID (sets the channel as the guild's feed channel).
@@ -124,13 +96,15 @@ func (db *SQLiteDatabase) SetFeedChannel(guildId string, channelId string) error
 	err = check.Scan(&currentChannelId)
 	if err == sql.ErrNoRows {
 		// Insert new row if none found
-		stmt, err = db.connection.Prepare("INSERT INTO Servers (guildId, channelId, lastAnnouncedAt) VALUES (?, ?, ?)")
+		// New guilds get the chapters from the past week announced
+		stmt, err = db.connection.Prepare("INSERT INTO Servers (guildId, channelId, lastAnnouncedAt, announceFrom) VALUES (?, ?, ?, ?)")
 		if err != nil {
 			return err
 		}
 		defer stmt.Close()
 
-		_, err := stmt.Exec(guildId, channelId, time.Now().Add((time.Hour*24*7)*-1).UTC())
+		announceFrom := time.Now().Add((time.Hour * 24 * 7) * -1).UTC()
+		_, err := stmt.Exec(guildId, channelId, announceFrom, announceFrom)
 		if err != nil {
 			return err
 		}
@@ -215,46 +189,101 @@ func (db *SQLiteDatabase) SetLastAnnouncedTime(guildId string, lastAnnouncedAt t
 	return nil
 }
 
-// Gets the status for the announcing server flag of a certain guild.
-func (db *SQLiteDatabase) GetAnnouncingServerFlag(guildId string) (bool, error) {
-	stmt, err := db.connection.Prepare("SELECT isAnnouncing FROM Servers WHERE guildId = ?")
+// Sets the time the last daily digest was sent to a certain guild.
+func (db *SQLiteDatabase) SetLastDigestTime(guildId string, lastDigestAt time.Time) error {
+	stmt, err := db.connection.Prepare("UPDATE Servers SET lastDigestAt = ? WHERE guildId = ?")
 	if err != nil {
-		return true, err
+		return err
 	}
 	defer stmt.Close()
 
-	check := stmt.QueryRow(guildId)
-	var isAnnouncing int
-	err = check.Scan(&isAnnouncing)
-	if err == sql.ErrNoRows {
-		return true, &NoFeedChannelSetError{}
+	exec, err := stmt.Exec(lastDigestAt.UTC(), guildId)
+	if err != nil {
+		return err
 	}
 
-	if isAnnouncing == 0 {
-		return false, nil
-	} else if isAnnouncing >= 1 {
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &NoFeedChannelSetError{}
+	}
+
+	return nil
+}
+
+// Takes the announcing lease of a certain guild, so only one announcer works on it at a time.
+// The lease can be taken if nobody holds it, if it has expired, or if the owner already holds it.
+// Returns false if someone else holds a lease that hasn't expired yet.
+func (db *SQLiteDatabase) AcquireAnnouncingLease(guildId string, owner string, duration time.Duration) (bool, error) {
+	now := time.Now().UTC()
+
+	stmt, err := db.connection.Prepare(`
+		UPDATE Servers SET announcingOwner = ?, announcingUntil = ?
+		WHERE guildId = ?
+		AND (announcingOwner IS NULL OR announcingOwner = ? OR announcingUntil IS NULL OR announcingUntil < ?)
+	`)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(owner, now.Add(duration), guildId, owner, now)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected > 0 {
 		return true, nil
 	}
 
-	return true, nil
+	// Nothing was updated; either the lease is held, or the guild doesn't exist
+	_, err = db.GetFeedChannel(guildId)
+	if err != nil {
+		return false, err
+	}
+
+	return false, nil
 }
 
-// Sets the... see above.
-func (db *SQLiteDatabase) SetAnnouncingServerFlag(guildId string, announcing bool) error {
-	stmt, err := db.connection.Prepare("UPDATE Servers SET isAnnouncing = ? WHERE guildId = ?")
+// Extends the announcing lease, as long as the owner still holds it.
+func (db *SQLiteDatabase) RenewAnnouncingLease(guildId string, owner string, duration time.Duration) error {
+	stmt, err := db.connection.Prepare("UPDATE Servers SET announcingUntil = ? WHERE guildId = ? AND announcingOwner = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	var boolint int
-	if announcing {
-		boolint = 1
-	} else {
-		boolint = 0
+	_, err = stmt.Exec(time.Now().UTC().Add(duration), guildId, owner)
+	return err
+}
+
+// Gives the announcing lease back, as long as the owner still holds it.
+func (db *SQLiteDatabase) ReleaseAnnouncingLease(guildId string, owner string) error {
+	stmt, err := db.connection.Prepare("UPDATE Servers SET announcingOwner = NULL, announcingUntil = NULL WHERE guildId = ? AND announcingOwner = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(guildId, owner)
+	return err
+}
+
+// Forcefully clears the announcing lease of a certain guild, regardless of who holds it.
+func (db *SQLiteDatabase) ClearAnnouncingLease(guildId string) error {
+	stmt, err := db.connection.Prepare("UPDATE Servers SET announcingOwner = NULL, announcingUntil = NULL WHERE guildId = ?")
+	if err != nil {
+		return err
 	}
+	defer stmt.Close()
 
-	exec, err := stmt.Exec(boolint, guildId)
+	exec, err := stmt.Exec(guildId)
 	if err != nil {
 		return err
 	}
@@ -270,13 +299,41 @@ func (db *SQLiteDatabase) SetAnnouncingServerFlag(guildId string, announcing boo
 	return nil
 }
 
+// Clears every announcing lease. Used on startup, when no announcer can be running yet,
+// to recover the leases left behind by a process that died mid-announcement.
+// Returns how many leases were cleared.
+func (db *SQLiteDatabase) ClearAllAnnouncingLeases() (int64, error) {
+	exec, err := db.connection.Exec("UPDATE Servers SET announcingOwner = NULL, announcingUntil = NULL WHERE announcingOwner IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+
+	return exec.RowsAffected()
+}
+
 // Saves an array of chapters to the database.
-func (db *SQLiteDatabase) SaveChapters(chapters *[]types.Chapter) error {
+// Returns the number of chapters that were newly inserted.
+// Chapters of a manga that has no chapters in the database yet are saved as backfill,
+// since they're mostly the manga's back catalogue rather than new releases
+// (see GetUnannouncedChapters() for which of them are still announced).
+func (db *SQLiteDatabase) SaveChapters(chapters *[]types.Chapter) (int, error) {
+	inserted := 0
+	isBackfill := make(map[string]bool)
 	for _, chapter := range *chapters {
+		backfill, checked := isBackfill[chapter.Manga]
+		if !checked {
+			exists, err := db.CheckMangaExistence(chapter.Manga)
+			if err != nil {
+				return inserted, err
+			}
+			backfill = !exists
+			isBackfill[chapter.Manga] = backfill
+		}
+
 		// Check if exists; only write if it doesn't
 		stmt, err := db.connection.Prepare("SELECT id FROM Chapters WHERE manga = ? AND title = ? AND number = ?")
 		if err != nil {
-			return err
+			return inserted, err
 		}
 		defer stmt.Close()
 
@@ -286,31 +343,40 @@ func (db *SQLiteDatabase) SaveChapters(chapters *[]types.Chapter) error {
 			fmt.Println(helpers.FormattedNow(), "Saving new chapter... ["+chapter.Manga+"]:", chapter.Title)
 
 			// Insert new row
-			stmt, err = db.connection.Prepare("INSERT INTO Chapters (manga, title, number, url, date, loggedAt) VALUES (?, ?, ?, ?, ?, ?)")
+			stmt, err = db.connection.Prepare(`
+				INSERT INTO Chapters (manga, title, number, url, date, loggedAt, isBackfill, thumbnail, description, author, access)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`)
 			if err != nil {
-				return err
+				return inserted, err
 			}
 			defer stmt.Close()
 
-			_, err := stmt.Exec(chapter.Manga, chapter.Title, chapter.Number, chapter.Url, chapter.Date.UTC(), time.Now().UTC())
+			_, err := stmt.Exec(
+				chapter.Manga, chapter.Title, chapter.Number, chapter.Url, chapter.Date.UTC(), time.Now().UTC(), backfill,
+				chapter.Thumbnail, chapter.Description, chapter.Author, chapter.Access,
+			)
 			if err != nil {
-				return err
+				return inserted, err
 			}
+			inserted++
 		}
 	}
 
-	return nil
+	return inserted, nil
 }
 
 // Get unannounced chapters for a specific guild.
-// How a chapter is "unannounced" is determined by:
-// (1) the guild's lastAnnouncedAt; (2) the chapter's loggedAt; and (3) the chapter's publish date.
-// If a chapter is logged into the database AFTER a guild's last announcement timestamp,
-// it means the chapter is new and thus needs to be announced...
-// UNLESS that chapter was released BEFORE the guild's last announcement time,
-// which means the chapter is actually old, but was just logged into the database recently.
+// A chapter is "unannounced" if it has no delivery to the guild yet, or if its delivery to one of
+// the guild's channels is still pending, or failed and hasn't reached MaxDeliveryAttempts.
+// GetFinishedDeliveryChannels() tells which of the guild's channels don't need the chapter anymore.
+// Only chapters logged after the guild's announceFrom (about when it registered) are considered.
+// Backfilled chapters are only considered if they're dated after announceFrom,
+// so a new target's recent releases are announced but not its back catalogue.
+// If the guild follows some titles, only the chapters of those titles are considered.
+// The publish date doesn't matter otherwise, so back-dated releases still get announced.
 func (db *SQLiteDatabase) GetUnannouncedChapters(guildId string) (*[]types.Chapter, error) {
-	lastAnnouncedAt, err := db.GetLastAnnouncedTime(guildId)
+	_, err := db.GetFeedChannel(guildId)
 	if err != nil {
 		return nil, err
 	}
@@ -318,87 +384,232 @@ func (db *SQLiteDatabase) GetUnannouncedChapters(guildId string) (*[]types.Chapt
 	var chapters []types.Chapter
 
 	stmt, err := db.connection.Prepare(`
-		SELECT manga, title, number, url, date, loggedAt
-		FROM Chapters
-		WHERE loggedAt > ?
-		AND date > ?
-		ORDER BY date ASC
+		SELECT c.id, c.manga, c.title, c.number, c.url, c.date, c.loggedAt, c.thumbnail, c.description, c.author, c.access
+		FROM Chapters c, Servers s
+		WHERE s.guildId = ?
+		AND c.loggedAt > s.announceFrom
+		AND (c.isBackfill = 0 OR c.date > s.announceFrom)
+		AND (
+			NOT EXISTS (SELECT id FROM Follows f WHERE f.guildId = s.guildId)
+			OR c.manga IN (SELECT manga FROM Follows f WHERE f.guildId = s.guildId)
+		)
+		AND (
+			NOT EXISTS (SELECT id FROM Deliveries d WHERE d.guildId = s.guildId AND d.chapterId = c.id)
+			OR EXISTS (
+				SELECT id FROM Deliveries d
+				WHERE d.guildId = s.guildId AND d.chapterId = c.id
+				AND (d.status = ? OR (d.status = ? AND d.attempts < ?))
+			)
+		)
+		ORDER BY c.date ASC, c.id ASC
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(lastAnnouncedAt, lastAnnouncedAt)
+	rows, err := stmt.Query(guildId, DeliveryPending, DeliveryFailed, MaxDeliveryAttempts)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
+		var id int64
 		var manga string
 		var title string
 		var number string
 		var url string
 		var date time.Time
 		var loggedAt time.Time
-		err = rows.Scan(&manga, &title, &number, &url, &date, &loggedAt)
+		var thumbnail string
+		var description string
+		var author string
+		var access string
+		err = rows.Scan(&id, &manga, &title, &number, &url, &date, &loggedAt, &thumbnail, &description, &author, &access)
 		if err != nil {
 			return nil, err
 		}
 		chapters = append(chapters, types.Chapter{
-			Manga:    manga,
-			Title:    title,
-			Number:   number,
-			Url:      url,
-			Date:     date,
-			LoggedAt: loggedAt,
+			Id:          id,
+			Manga:       manga,
+			Title:       title,
+			Number:      number,
+			Url:         url,
+			Date:        date,
+			LoggedAt:    loggedAt,
+			Thumbnail:   thumbnail,
+			Description: description,
+			Author:      author,
+			Access:      access,
 		})
 	}
 
 	return &chapters, nil
 }
 
+// Records the delivery of a chapter to a guild's channel.
+// Every failed delivery counts as an attempt, and a pending one doesn't;
+// once delivered or skipped, the chapter is never announced again.
+func (db *SQLiteDatabase) SaveDelivery(delivery types.Delivery) error {
+	failed := 0
+	if delivery.Status == DeliveryFailed {
+		failed = 1
+	}
+
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO Deliveries (guildId, channelId, chapterId, status, messageId, attempts, error, updatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(guildId, chapterId, channelId) DO UPDATE SET
+			status = excluded.status,
+			messageId = excluded.messageId,
+			attempts = attempts + excluded.attempts,
+			error = excluded.error,
+			updatedAt = excluded.updatedAt
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(delivery.GuildId, delivery.ChannelId, delivery.ChapterId, delivery.Status, delivery.MessageId, failed, delivery.Error, time.Now().UTC())
+	return err
+}
+
+// Gets the channels of a guild that a chapter doesn't need to be delivered to anymore,
+// because it has been delivered (or skipped), or because it failed too many times.
+func (db *SQLiteDatabase) GetFinishedDeliveryChannels(guildId string, chapterId int64) ([]string, error) {
+	var channelIds []string
+
+	stmt, err := db.connection.Prepare("SELECT channelId FROM Deliveries WHERE guildId = ? AND chapterId = ? AND ((status != ? AND status != ?) OR attempts >= ?)")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(guildId, chapterId, DeliveryPending, DeliveryFailed, MaxDeliveryAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var channelId string
+		err = rows.Scan(&channelId)
+		if err != nil {
+			return nil, err
+		}
+		channelIds = append(channelIds, channelId)
+	}
+
+	return channelIds, nil
+}
+
+// The columns of Servers read into a types.Server, in the order scanServer expects them.
+const serverColumns = "guildId, channelId, lastAnnouncedAt, announcingOwner, announcingUntil, timezone, language, digestMode, lastDigestAt"
+
+// Reads a row of serverColumns.
+func scanServer(row interface{ Scan(...any) error }) (types.Server, error) {
+	var server types.Server
+	var announcingOwner sql.NullString
+	var announcingUntil sql.NullTime
+	var lastDigestAt sql.NullTime
+	err := row.Scan(
+		&server.Identifier,
+		&server.FeedChannelIdentifier,
+		&server.LastAnnouncedAt,
+		&announcingOwner,
+		&announcingUntil,
+		&server.Timezone,
+		&server.Language,
+		&server.DigestMode,
+		&lastDigestAt,
+	)
+	if err != nil {
+		return types.Server{}, err
+	}
+	server.AnnouncingOwner = announcingOwner.String
+	server.AnnouncingUntil = announcingUntil.Time
+	server.LastDigestAt = lastDigestAt.Time
+
+	return server, nil
+}
+
 // Gets all the guilds saved in the database.
 // Guilds are saved into the database whenever it sets a channel as its feed channel.
 // (see setFeedChannel() function)
 func (db *SQLiteDatabase) GetServers() ([]types.Server, error) {
 	var servers []types.Server
 
-	rows, err := db.connection.Query("SELECT guildId, channelId, lastAnnouncedAt, isAnnouncing FROM Servers")
+	rows, err := db.connection.Query("SELECT " + serverColumns + " FROM Servers")
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 	for rows.Next() {
-		var identifier string
-		var feedChannelIdentifier string
-		var lastAnnouncedAt time.Time
-		var isAnnouncingInt int
-		var isAnnouncing bool
-		err = rows.Scan(&identifier, &feedChannelIdentifier, &lastAnnouncedAt, &isAnnouncingInt)
+		server, err := scanServer(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		if isAnnouncingInt == 0 {
-			isAnnouncing = false
-		}
-		if isAnnouncingInt == 1 {
-			isAnnouncing = true
-		}
-
-		servers = append(servers, types.Server{
-			Identifier:            identifier,
-			FeedChannelIdentifier: feedChannelIdentifier,
-			LastAnnouncedAt:       lastAnnouncedAt,
-			IsAnnouncing:          isAnnouncing,
-		})
+		servers = append(servers, server)
 	}
 
 	return servers, nil
 }
 
+// Gets a single guild.
+func (db *SQLiteDatabase) GetServer(guildId string) (types.Server, error) {
+	stmt, err := db.connection.Prepare("SELECT " + serverColumns + " FROM Servers WHERE guildId = ?")
+	if err != nil {
+		return types.Server{}, err
+	}
+	defer stmt.Close()
+
+	server, err := scanServer(stmt.QueryRow(guildId))
+	if err == sql.ErrNoRows {
+		return types.Server{}, &NoFeedChannelSetError{}
+	}
+
+	return server, err
+}
+
+// Sets the timezone the dates are shown in for a certain guild. An empty string goes back to the default.
+func (db *SQLiteDatabase) SetServerTimezone(guildId string, timezone string) error {
+	return db.setServerSetting("timezone", guildId, timezone)
+}
+
+// Sets the language of the bot's replies in a certain guild. An empty string goes back to the default.
+func (db *SQLiteDatabase) SetServerLanguage(guildId string, language string) error {
+	return db.setServerSetting("language", guildId, language)
+}
+
+// Sets how the chapters are announced in a certain guild; one of the Digest* constants.
+func (db *SQLiteDatabase) SetServerDigestMode(guildId string, mode string) error {
+	return db.setServerSetting("digestMode", guildId, mode)
+}
+
+func (db *SQLiteDatabase) setServerSetting(column string, guildId string, value string) error {
+	stmt, err := db.connection.Prepare("UPDATE Servers SET " + column + " = ? WHERE guildId = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(value, guildId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &NoFeedChannelSetError{}
+	}
+
+	return nil
+}
+
 // Checks if a manga title exists in the Chapters table.
 func (db *SQLiteDatabase) CheckMangaExistence(title string) (bool, error) {
 	stmt, err := db.connection.Prepare("SELECT manga FROM Chapters WHERE manga = ? LIMIT 1")
@@ -507,3 +718,588 @@ func (db *SQLiteDatabase) GetSubscribers(guildId string, title string) ([]string
 
 	return userIds, nil
 }
+
+// Gets the validators (ETag and Last-Modified) of the last handled response of a target.
+// Returns an empty cache if the target has none.
+func (db *SQLiteDatabase) GetFetchCache(target string) (types.FetchCache, error) {
+	cache := types.FetchCache{Target: target}
+
+	stmt, err := db.connection.Prepare("SELECT etag, lastModified FROM FetchCaches WHERE target = ?")
+	if err != nil {
+		return cache, err
+	}
+	defer stmt.Close()
+
+	var etag sql.NullString
+	var lastModified sql.NullString
+	err = stmt.QueryRow(target).Scan(&etag, &lastModified)
+	if err == sql.ErrNoRows {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+
+	cache.ETag = etag.String
+	cache.LastModified = lastModified.String
+	return cache, nil
+}
+
+// Saves the validators of... see above.
+func (db *SQLiteDatabase) SetFetchCache(cache types.FetchCache) error {
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO FetchCaches (target, etag, lastModified, updatedAt) VALUES (?, ?, ?, ?)
+		ON CONFLICT(target) DO UPDATE SET etag = excluded.etag, lastModified = excluded.lastModified, updatedAt = excluded.updatedAt
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(cache.Target, cache.ETag, cache.LastModified, time.Now().UTC())
+	return err
+}
+
+// Saves the outcome of a single gofer run.
+func (db *SQLiteDatabase) SaveFetchRun(run types.FetchRun) error {
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO FetchRuns (target, startedAt, finishedAt, httpStatus, error, chaptersFound, chaptersParsed, chaptersInserted)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(run.Target, run.StartedAt.UTC(), run.FinishedAt.UTC(), run.HttpStatus, run.Error, run.ChaptersFound, run.ChaptersParsed, run.ChaptersInserted)
+	return err
+}
+
+// Scans the rows of a FetchRuns query into an array.
+func scanFetchRuns(rows *sql.Rows) ([]types.FetchRun, error) {
+	var runs []types.FetchRun
+
+	defer rows.Close()
+	for rows.Next() {
+		var run types.FetchRun
+		var runError sql.NullString
+		err := rows.Scan(&run.Id, &run.Target, &run.StartedAt, &run.FinishedAt, &run.HttpStatus, &runError, &run.ChaptersFound, &run.ChaptersParsed, &run.ChaptersInserted)
+		if err != nil {
+			return nil, err
+		}
+		run.Error = runError.String
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// Gets the most recent fetch runs of a target, newest first.
+func (db *SQLiteDatabase) GetFetchRuns(target string, limit int) ([]types.FetchRun, error) {
+	stmt, err := db.connection.Prepare(`
+		SELECT id, target, startedAt, finishedAt, httpStatus, error, chaptersFound, chaptersParsed, chaptersInserted
+		FROM FetchRuns
+		WHERE target = ?
+		ORDER BY startedAt DESC, id DESC
+		LIMIT ?
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(target, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanFetchRuns(rows)
+}
+
+// Gets the latest fetch run of every target that has been fetched at least once.
+func (db *SQLiteDatabase) GetLatestFetchRuns() ([]types.FetchRun, error) {
+	rows, err := db.connection.Query(`
+		SELECT id, target, startedAt, finishedAt, httpStatus, error, chaptersFound, chaptersParsed, chaptersInserted
+		FROM FetchRuns
+		WHERE id IN (SELECT MAX(id) FROM FetchRuns GROUP BY target)
+		ORDER BY target ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanFetchRuns(rows)
+}
+
+// Adds a title to the list of titles a guild follows.
+// A guild that follows no title at all gets the chapters of every title.
+func (db *SQLiteDatabase) FollowManga(guildId string, title string) error {
+	_, err := db.GetFeedChannel(guildId)
+	if err != nil {
+		return err
+	}
+
+	titleExists, err := db.CheckMangaExistence(title)
+	if err != nil {
+		return err
+	}
+	if !titleExists {
+		return &TitleDoesNotExistError{}
+	}
+
+	err = db.skipUnfollowedChapters(guildId)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.connection.Prepare("INSERT INTO Follows (guildId, manga) VALUES (?, ?) ON CONFLICT(guildId, manga) DO NOTHING")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(guildId, title)
+	return err
+}
+
+// Removes a title from the list of titles a guild follows.
+// Removing the last one makes the guild get every title again, but not the chapters it skipped while following.
+func (db *SQLiteDatabase) UnfollowManga(guildId string, title string) error {
+	err := db.skipUnfollowedChapters(guildId)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.connection.Prepare("DELETE FROM Follows WHERE guildId = ? AND manga = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(guildId, title)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &NotFollowingError{}
+	}
+
+	return nil
+}
+
+// Marks the chapters of the titles a guild doesn't follow as skipped, unless they've been delivered already.
+// This is done before the followed titles change, so following a title (or every title, by unfollowing the last one)
+// only brings the chapters logged from then on, instead of everything the guild didn't get while not following it.
+// Nothing is skipped while the guild follows every title.
+func (db *SQLiteDatabase) skipUnfollowedChapters(guildId string) error {
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO Deliveries (guildId, channelId, chapterId, status, attempts, updatedAt)
+		SELECT s.guildId, '', c.id, ?, 0, ?
+		FROM Chapters c, Servers s
+		WHERE s.guildId = ?
+		AND c.loggedAt > s.announceFrom
+		AND EXISTS (SELECT id FROM Follows f WHERE f.guildId = s.guildId)
+		AND c.manga NOT IN (SELECT manga FROM Follows f WHERE f.guildId = s.guildId)
+		AND NOT EXISTS (SELECT id FROM Deliveries d WHERE d.guildId = s.guildId AND d.chapterId = c.id)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(DeliverySkipped, time.Now().UTC(), guildId)
+	return err
+}
+
+// Gets the list of titles a guild follows.
+func (db *SQLiteDatabase) GetFollowedManga(guildId string) ([]string, error) {
+	var titles []string
+
+	stmt, err := db.connection.Prepare("SELECT manga FROM Follows WHERE guildId = ? ORDER BY manga ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(guildId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var title string
+		err = rows.Scan(&title)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+
+	return titles, nil
+}
+
+// Adds a route that sends a series' or a label's chapters to a channel.
+func (db *SQLiteDatabase) AddRoute(route types.Route) error {
+	_, err := db.GetFeedChannel(route.GuildId)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.connection.Prepare("INSERT INTO Routes (guildId, channelId, kind, value) VALUES (?, ?, ?, ?) ON CONFLICT(guildId, channelId, kind, value) DO NOTHING")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(route.GuildId, route.ChannelId, route.Kind, route.Value)
+	return err
+}
+
+// Removes a route.
+func (db *SQLiteDatabase) RemoveRoute(route types.Route) error {
+	stmt, err := db.connection.Prepare("DELETE FROM Routes WHERE guildId = ? AND channelId = ? AND kind = ? AND value = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(route.GuildId, route.ChannelId, route.Kind, route.Value)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &NoRouteFoundError{}
+	}
+
+	return nil
+}
+
+// Gets the routes of a guild.
+func (db *SQLiteDatabase) GetRoutes(guildId string) ([]types.Route, error) {
+	var routes []types.Route
+
+	stmt, err := db.connection.Prepare("SELECT guildId, channelId, kind, value FROM Routes WHERE guildId = ? ORDER BY kind ASC, value ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(guildId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var route types.Route
+		err = rows.Scan(&route.GuildId, &route.ChannelId, &route.Kind, &route.Value)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// Sets the announcement template of a guild, or of one of its series, replacing the one it had.
+func (db *SQLiteDatabase) SetTemplate(template types.Template) error {
+	_, err := db.GetFeedChannel(template.GuildId)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO Templates (guildId, series, template, embed) VALUES (?, ?, ?, ?)
+		ON CONFLICT(guildId, series) DO UPDATE SET template = excluded.template, embed = excluded.embed
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(template.GuildId, template.Series, template.Text, template.Embed)
+	return err
+}
+
+// Removes the announcement template of a guild, or of one of its series.
+func (db *SQLiteDatabase) RemoveTemplate(guildId string, series string) error {
+	stmt, err := db.connection.Prepare("DELETE FROM Templates WHERE guildId = ? AND series = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(guildId, series)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &NoTemplateFoundError{}
+	}
+
+	return nil
+}
+
+// Gets the announcement templates of a guild.
+func (db *SQLiteDatabase) GetTemplates(guildId string) ([]types.Template, error) {
+	var templates []types.Template
+
+	stmt, err := db.connection.Prepare("SELECT guildId, series, template, embed FROM Templates WHERE guildId = ? ORDER BY series ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(guildId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var template types.Template
+		var embed int
+		err = rows.Scan(&template.GuildId, &template.Series, &template.Text, &embed)
+		if err != nil {
+			return nil, err
+		}
+		template.Embed = embed != 0
+		templates = append(templates, template)
+	}
+
+	return templates, nil
+}
+
+// Saves the targets from the config into the database.
+// New targets are inserted, targets that still come from the config are updated to match it,
+// and targets from the config that are no longer in it are removed, along with the removed targets that aren't in it anymore.
+// Targets that were added, edited or removed with commands are left alone.
+func (db *SQLiteDatabase) SeedTargets(targets []types.Target) error {
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO Targets (name, definition, enabled, origin, updatedAt) VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET definition = excluded.definition, updatedAt = excluded.updatedAt
+		WHERE origin = excluded.origin AND definition != excluded.definition
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, target := range targets {
+		definition, err := json.Marshal(target)
+		if err != nil {
+			return err
+		}
+
+		_, err = stmt.Exec(target.Name, string(definition), TargetFromConfig, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	query := "DELETE FROM Targets WHERE origin IN (?, ?)"
+	args := []any{TargetFromConfig, TargetRemoved}
+	if len(targets) > 0 {
+		query += " AND name NOT IN (?" + strings.Repeat(", ?", len(targets)-1) + ")"
+		for _, target := range targets {
+			args = append(args, target.Name)
+		}
+	}
+	_, err = db.connection.Exec(query, args...)
+
+	return err
+}
+
+// Scans a row of the Targets table.
+func scanTargetEntry(row interface{ Scan(...any) error }) (types.TargetEntry, error) {
+	var entry types.TargetEntry
+	var definition string
+	var enabled int
+	err := row.Scan(&definition, &enabled, &entry.Origin, &entry.UpdatedAt)
+	if err != nil {
+		return entry, err
+	}
+
+	err = json.Unmarshal([]byte(definition), &entry.Target)
+	if err != nil {
+		return entry, err
+	}
+	entry.Enabled = enabled != 0
+
+	return entry, nil
+}
+
+// Gets every stored target, enabled or not. Removed targets are left out.
+func (db *SQLiteDatabase) GetTargets() ([]types.TargetEntry, error) {
+	var entries []types.TargetEntry
+
+	rows, err := db.connection.Query("SELECT definition, enabled, origin, updatedAt FROM Targets WHERE origin != ? ORDER BY id ASC", TargetRemoved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry, err := scanTargetEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Gets a stored target by its name.
+func (db *SQLiteDatabase) GetTarget(name string) (types.TargetEntry, error) {
+	stmt, err := db.connection.Prepare("SELECT definition, enabled, origin, updatedAt FROM Targets WHERE name = ? AND origin != ?")
+	if err != nil {
+		return types.TargetEntry{}, err
+	}
+	defer stmt.Close()
+
+	entry, err := scanTargetEntry(stmt.QueryRow(name, TargetRemoved))
+	if err == sql.ErrNoRows {
+		return entry, &TargetDoesNotExistError{}
+	}
+
+	return entry, err
+}
+
+// Adds a new target, or brings back a removed one with the new definition.
+func (db *SQLiteDatabase) AddTarget(target types.Target) error {
+	definition, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.connection.Prepare(`
+		INSERT INTO Targets (name, definition, enabled, origin, updatedAt) VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET definition = excluded.definition, enabled = 1, origin = excluded.origin, updatedAt = excluded.updatedAt
+		WHERE origin = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(target.Name, string(definition), TargetFromDiscord, time.Now().UTC(), TargetRemoved)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &TargetAlreadyExistsError{}
+	}
+
+	return nil
+}
+
+// Replaces the definition of a stored target.
+// The target is marked as edited with commands, so seeding from the config won't overwrite it.
+// The new definition has to keep the target's name.
+func (db *SQLiteDatabase) UpdateTarget(name string, target types.Target) error {
+	if target.Name != name {
+		var existing int
+		err := db.connection.QueryRow("SELECT COUNT(id) FROM Targets WHERE name = ?", target.Name).Scan(&existing)
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return &TargetAlreadyExistsError{}
+		}
+		return &TargetRenameError{}
+	}
+
+	definition, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.connection.Prepare("UPDATE Targets SET definition = ?, origin = ?, updatedAt = ? WHERE name = ? AND origin != ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(string(definition), TargetFromDiscord, time.Now().UTC(), name, TargetRemoved)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &TargetDoesNotExistError{}
+	}
+
+	return nil
+}
+
+// Enables or disables a stored target. Disabled targets are not fetched.
+func (db *SQLiteDatabase) SetTargetEnabled(name string, enabled bool) error {
+	stmt, err := db.connection.Prepare("UPDATE Targets SET enabled = ?, updatedAt = ? WHERE name = ? AND origin != ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(enabled, time.Now().UTC(), name, TargetRemoved)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &TargetDoesNotExistError{}
+	}
+
+	return nil
+}
+
+// Removes a stored target. Its chapters are kept.
+// The target is kept as a disabled row until it's gone from the config too, so seeding doesn't bring it back.
+func (db *SQLiteDatabase) RemoveTarget(name string) error {
+	stmt, err := db.connection.Prepare("UPDATE Targets SET enabled = 0, origin = ?, updatedAt = ? WHERE name = ? AND origin != ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	exec, err := stmt.Exec(TargetRemoved, time.Now().UTC(), name, TargetRemoved)
+	if err != nil {
+		return err
+	}
+
+	affected, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return &TargetDoesNotExistError{}
+	}
+
+	return nil
+}
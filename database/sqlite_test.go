@@ -0,0 +1,373 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// Opens a new database in a temporary directory, migrated to the latest version.
+func openTestDatabase(t *testing.T) *SQLiteDatabase {
+	t.Helper()
+
+	db, err := OpenSQLiteDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// Saves a chapter of a manga, published at the date.
+func saveTestChapter(t *testing.T, db *SQLiteDatabase, manga string, number string, date time.Time) {
+	t.Helper()
+
+	chapters := []types.Chapter{{
+		Manga:  manga,
+		Number: number,
+		Title:  manga + " " + number,
+		Url:    "https://comic.com/" + manga + "/" + number,
+		Date:   date,
+	}}
+	inserted, err := db.SaveChapters(&chapters)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if inserted != 1 {
+		t.Fatal("Expected the chapter to be inserted:", manga, number)
+	}
+}
+
+// Checks that exactly the expected chapters are unannounced for the guild, in order.
+func expectUnannounced(t *testing.T, db *SQLiteDatabase, guildId string, titles ...string) {
+	t.Helper()
+
+	chapters, err := db.GetUnannouncedChapters(guildId)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var found []string
+	for _, chapter := range *chapters {
+		found = append(found, chapter.Title)
+	}
+	if len(found) != len(titles) {
+		t.Fatal("Expected", titles, "but found", found)
+	}
+	for i := range titles {
+		if found[i] != titles[i] {
+			t.Fatal("Expected", titles, "but found", found)
+		}
+	}
+}
+
+func TestFollowingTitleLater(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := db.SetFeedChannel("guild", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	now := time.Now()
+	saveTestChapter(t, db, "Alpha", "1", now.Add(-2*time.Hour))
+	saveTestChapter(t, db, "Beta", "1", now.Add(-time.Hour))
+
+	if err := db.FollowManga("guild", "Alpha"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild", "Alpha 1")
+
+	// Beta's chapter came while the guild didn't follow it, so following Beta only brings the ones after
+	if err := db.FollowManga("guild", "Beta"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild", "Alpha 1")
+
+	saveTestChapter(t, db, "Beta", "2", now)
+	expectUnannounced(t, db, "guild", "Alpha 1", "Beta 2")
+}
+
+func TestUnfollowingLastTitle(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := db.SetFeedChannel("guild", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	now := time.Now()
+	saveTestChapter(t, db, "Alpha", "1", now.Add(-3*time.Hour))
+	saveTestChapter(t, db, "Beta", "1", now.Add(-2*time.Hour))
+	saveTestChapter(t, db, "Gamma", "1", now.Add(-time.Hour))
+
+	if err := db.FollowManga("guild", "Alpha"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild", "Alpha 1")
+
+	// Following every title again doesn't bring the chapters skipped until now
+	if err := db.UnfollowManga("guild", "Alpha"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild", "Alpha 1")
+
+	saveTestChapter(t, db, "Beta", "2", now)
+	expectUnannounced(t, db, "guild", "Alpha 1", "Beta 2")
+
+	// A guild that doesn't follow anything doesn't skip anything
+	if err := db.SetFeedChannel("other", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.UnfollowManga("other", "Alpha"); err == nil {
+		t.Error("Expected an error for unfollowing a title that isn't followed")
+	}
+	expectUnannounced(t, db, "other", "Alpha 1", "Beta 1", "Gamma 1", "Beta 2")
+}
+
+func TestPendingDeliveries(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := db.SetFeedChannel("guild", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	saveTestChapter(t, db, "Alpha", "1", time.Now())
+	chapters, err := db.GetUnannouncedChapters("guild")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	chapterId := (*chapters)[0].Id
+
+	// The bot stopped after sending to the first channel but before the second one
+	for _, channelId := range []string{"first", "second"} {
+		err = db.SaveDelivery(types.Delivery{GuildId: "guild", ChannelId: channelId, ChapterId: chapterId, Status: DeliveryPending})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	err = db.SaveDelivery(types.Delivery{GuildId: "guild", ChannelId: "first", ChapterId: chapterId, Status: DeliveryDelivered})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expectUnannounced(t, db, "guild", "Alpha 1")
+	finished, err := db.GetFinishedDeliveryChannels("guild", chapterId)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(finished) != 1 || finished[0] != "first" {
+		t.Fatal("Expected only the first channel to be finished, but found", finished)
+	}
+
+	err = db.SaveDelivery(types.Delivery{GuildId: "guild", ChannelId: "second", ChapterId: chapterId, Status: DeliveryDelivered})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild")
+}
+
+func TestRemovedConfigTargetStaysRemoved(t *testing.T) {
+	db := openTestDatabase(t)
+	targets := []types.Target{
+		{Name: "Alpha", Mode: "rss", Source: "https://comic.com/alpha.rss"},
+		{Name: "Beta", Mode: "rss", Source: "https://comic.com/beta.rss"},
+	}
+	if err := db.SeedTargets(targets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := db.RemoveTarget("Alpha"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.SeedTargets(targets); err != nil {
+		t.Fatal(err.Error())
+	}
+	entries, err := db.GetTargets()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 1 || entries[0].Target.Name != "Beta" {
+		t.Fatal("Expected only Beta to be left, but found", entries)
+	}
+	if _, err := db.GetTarget("Alpha"); err == nil {
+		t.Error("Expected the removed target not to be found")
+	}
+	if err := db.SetTargetEnabled("Alpha", true); err == nil {
+		t.Error("Expected the removed target not to be enabled")
+	}
+
+	// Adding it again brings it back
+	if err := db.AddTarget(targets[0]); err != nil {
+		t.Fatal(err.Error())
+	}
+	entry, err := db.GetTarget("Alpha")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !entry.Enabled || entry.Origin != TargetFromDiscord {
+		t.Error("Expected the target to be back and enabled, but found", entry)
+	}
+}
+
+func TestRenamingTarget(t *testing.T) {
+	db := openTestDatabase(t)
+	targets := []types.Target{
+		{Name: "Alpha", Mode: "rss", Source: "https://comic.com/alpha.rss"},
+		{Name: "Beta", Mode: "rss", Source: "https://comic.com/beta.rss"},
+	}
+	if err := db.SeedTargets(targets); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	renamed := targets[0]
+	renamed.Name = "Gamma"
+	if _, ok := db.UpdateTarget("Alpha", renamed).(*TargetRenameError); !ok {
+		t.Error("Expected renaming a target to be refused")
+	}
+	renamed.Name = "Beta"
+	if _, ok := db.UpdateTarget("Alpha", renamed).(*TargetAlreadyExistsError); !ok {
+		t.Error("Expected renaming a target to a taken name to be refused")
+	}
+
+	edited := targets[0]
+	edited.Source = "https://comic.com/alpha.xml"
+	if err := db.UpdateTarget("Alpha", edited); err != nil {
+		t.Fatal(err.Error())
+	}
+	entry, err := db.GetTarget("Alpha")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if entry.Target.Source != edited.Source {
+		t.Error("Expected the target to be edited, but found", entry.Target)
+	}
+}
+
+func TestFailedDeliveryRetries(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := db.SetFeedChannel("guild", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	saveTestChapter(t, db, "Alpha", "1", time.Now())
+	chapters, err := db.GetUnannouncedChapters("guild")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	chapterId := (*chapters)[0].Id
+
+	failure := types.Delivery{GuildId: "guild", ChannelId: "feed", ChapterId: chapterId, Status: DeliveryFailed, Error: "Missing Access"}
+	for attempt := 1; attempt < MaxDeliveryAttempts; attempt++ {
+		if err := db.SaveDelivery(failure); err != nil {
+			t.Fatal(err.Error())
+		}
+		expectUnannounced(t, db, "guild", "Alpha 1")
+
+		finished, err := db.GetFinishedDeliveryChannels("guild", chapterId)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(finished) > 0 {
+			t.Fatal("Expected the channel to be retried after", attempt, "attempts, but found", finished)
+		}
+	}
+
+	// The last attempt gives up on the chapter
+	if err := db.SaveDelivery(failure); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild")
+
+	finished, err := db.GetFinishedDeliveryChannels("guild", chapterId)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(finished) != 1 || finished[0] != "feed" {
+		t.Fatal("Expected the channel to be given up on, but found", finished)
+	}
+}
+
+func TestBackfillAgainstAnnounceFrom(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := db.SetFeedChannel("guild", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// New guilds start a week back, so the first fetch of a series only brings its releases from that week
+	now := time.Now()
+	backfill := []types.Chapter{
+		{Manga: "Alpha", Number: "1", Title: "Alpha 1", Url: "https://comic.com/Alpha/1", Date: now.Add(-30 * 24 * time.Hour)},
+		{Manga: "Alpha", Number: "2", Title: "Alpha 2", Url: "https://comic.com/Alpha/2", Date: now.Add(-24 * time.Hour)},
+	}
+	if _, err := db.SaveChapters(&backfill); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectUnannounced(t, db, "guild", "Alpha 2")
+
+	// Chapters after the first fetch aren't backfill, so they're announced even if they're back-dated
+	saveTestChapter(t, db, "Alpha", "3", now.Add(-10*24*time.Hour))
+	expectUnannounced(t, db, "guild", "Alpha 3", "Alpha 2")
+}
+
+// Tries to take a guild's announcing lease, and checks whether it was taken.
+func expectLease(t *testing.T, db *SQLiteDatabase, owner string, duration time.Duration, expected bool) {
+	t.Helper()
+
+	acquired, err := db.AcquireAnnouncingLease("guild", owner, duration)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if acquired != expected {
+		t.Fatal("Expected", owner, "taking the lease to be", expected, "but it was", acquired)
+	}
+}
+
+func TestAnnouncingLease(t *testing.T) {
+	db := openTestDatabase(t)
+	if err := db.SetFeedChannel("guild", "feed"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Only one announcer holds the lease at a time, and its owner can take it again
+	expectLease(t, db, "first", time.Minute, true)
+	expectLease(t, db, "second", time.Minute, false)
+	expectLease(t, db, "first", time.Minute, true)
+
+	// Only the owner can renew or release it
+	if err := db.ReleaseAnnouncingLease("guild", "second"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectLease(t, db, "second", time.Minute, false)
+
+	// A lease that expired, like one left behind by a dead process, can be taken over
+	if err := db.RenewAnnouncingLease("guild", "first", -time.Second); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectLease(t, db, "second", time.Minute, true)
+
+	server, err := db.GetServer("guild")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if server.AnnouncingOwner != "second" || !server.AnnouncingUntil.After(time.Now()) {
+		t.Error("Expected the lease to be taken over, but found", server.AnnouncingOwner, server.AnnouncingUntil)
+	}
+
+	// The old owner can't extend or give back a lease it lost
+	if err := db.RenewAnnouncingLease("guild", "first", time.Hour); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := db.ReleaseAnnouncingLease("guild", "first"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectLease(t, db, "first", time.Minute, false)
+
+	if err := db.ReleaseAnnouncingLease("guild", "second"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectLease(t, db, "first", time.Minute, true)
+
+	// A guild that isn't registered has no lease to take
+	if _, err := db.AcquireAnnouncingLease("other", "first", time.Minute); err == nil {
+		t.Error("Expected an error for a guild that isn't registered")
+	}
+}
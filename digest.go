@@ -0,0 +1,307 @@
+// This file handles the digests guilds can get instead of an announcement for every chapter.
+// A digest announces the chapters of a series (or of a whole day) together, with the guild's templates,
+// packing them into as few messages as Discord accepts, and mentions the subscribers once.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hermitpopcorn/decatholac-mango/database"
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// Discord refuses messages with more embeds than this, or with more characters than this in all their embeds together.
+const (
+	maxEmbedsPerMessage          = 10
+	maxEmbedCharactersPerMessage = 6000
+)
+
+// Checks if a guild gets its chapters in digests.
+func isDigestMode(server *types.Server) bool {
+	return server.DigestMode == database.DigestSeries || server.DigestMode == database.DigestDaily
+}
+
+// Checks if it's time to announce to a guild.
+// Guilds getting a daily digest are only announced to once a day, the first time after midnight in their timezone.
+func isDigestDue(server *types.Server, now time.Time) bool {
+	if server.DigestMode != database.DigestDaily || server.LastDigestAt.IsZero() {
+		return true
+	}
+
+	location := getTimezone(server)
+	lastYear, lastMonth, lastDay := server.LastDigestAt.In(location).Date()
+	year, month, day := now.In(location).Date()
+	return year != lastYear || month != lastMonth || day != lastDay
+}
+
+// Counts the characters of an embed the way Discord does for its limit.
+func getEmbedLength(embed *discordgo.MessageEmbed) int {
+	length := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	if embed.Author != nil {
+		length += utf8.RuneCountInString(embed.Author.Name)
+	}
+	if embed.Footer != nil {
+		length += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	for _, field := range embed.Fields {
+		length += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+
+	return length
+}
+
+// A chapter's part of a digest: the line its template writes, if the guild has one,
+// and its embed, unless the template turns it off.
+type digestEntry struct {
+	chapter *types.Chapter
+	line    string
+	embed   *discordgo.MessageEmbed
+}
+
+// Makes the entries of a digest with the guild's templates, the same way a chapter is announced on its own.
+func makeDigestEntries(server *types.Server, templates []types.Template, chapters []*types.Chapter) []digestEntry {
+	entries := make([]digestEntry, len(chapters))
+	for i, chapter := range chapters {
+		announcement := makeAnnouncement(server, findTemplate(templates, chapter.Manga), chapter)
+		entries[i] = digestEntry{chapter: chapter, line: announcement.Content}
+		if len(announcement.Embeds) > 0 {
+			entries[i].embed = announcement.Embeds[0]
+		}
+	}
+
+	return entries
+}
+
+// Splits the entries of a digest into the messages they're sent in, keeping their order.
+// The header starts the first message, and the lines of the entries follow it.
+// Returns how many of the entries go in each message.
+func packDigest(header string, entries []digestEntry) []int {
+	var counts []int
+	count, embeds, embedLength, contentLength := 0, 0, 0, utf8.RuneCountInString(header)
+	for _, entry := range entries {
+		entryEmbeds, entryEmbedLength := 0, 0
+		if entry.embed != nil {
+			entryEmbeds, entryEmbedLength = 1, getEmbedLength(entry.embed)
+		}
+		entryContentLength := 0
+		if entry.line != "" {
+			entryContentLength = utf8.RuneCountInString(entry.line) + 1 // And the line break before it
+		}
+
+		if count > 0 && (embeds+entryEmbeds > maxEmbedsPerMessage ||
+			embedLength+entryEmbedLength > maxEmbedCharactersPerMessage ||
+			contentLength+entryContentLength > maxMessageLength) {
+			counts = append(counts, count)
+			count, embeds, embedLength, contentLength = 0, 0, 0, 0
+		}
+		count++
+		embeds += entryEmbeds
+		embedLength += entryEmbedLength
+		contentLength += entryContentLength
+	}
+	if count > 0 {
+		counts = append(counts, count)
+	}
+
+	return counts
+}
+
+// Groups the chapters going to a channel into digests: one for each series, or one for all of them.
+// The series are in the order their first chapter comes in.
+func groupDigests(server *types.Server, chapters []*types.Chapter) [][]*types.Chapter {
+	if server.DigestMode != database.DigestSeries {
+		return [][]*types.Chapter{chapters}
+	}
+
+	var groups [][]*types.Chapter
+	index := make(map[string]int)
+	for _, chapter := range chapters {
+		i, ok := index[chapter.Manga]
+		if !ok {
+			i = len(groups)
+			index[chapter.Manga] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], chapter)
+	}
+
+	return groups
+}
+
+// Sends a digest of chapters to a channel, and records the delivery of every chapter in it.
+// The header is written in the first message, and the owner's announcing lease is renewed after every message.
+// A message that fails doesn't stop the ones after it,
+// but cancelling the context does; the chapters that weren't sent are left for the next announcement.
+// Returns the chapters that were delivered.
+func sendDigest(ctx context.Context, db database.Database, session *discordgo.Session, server *types.Server, owner string, templates []types.Template, channelId string, header string, chapters []*types.Chapter) ([]*types.Chapter, error) {
+	entries := makeDigestEntries(server, templates, chapters)
+
+	var delivered []*types.Chapter
+	var firstErr error
+	start := 0
+	for i, count := range packDigest(header, entries) {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+
+		var lines []string
+		if i == 0 {
+			lines = append(lines, header)
+		}
+		send := &discordgo.MessageSend{}
+		for _, entry := range entries[start : start+count] {
+			if entry.line != "" {
+				lines = append(lines, entry.line)
+			}
+			if entry.embed != nil {
+				send.Embeds = append(send.Embeds, entry.embed)
+			}
+		}
+		send.Content = truncateMessage(strings.Join(lines, "\n"))
+
+		delivery := types.Delivery{
+			GuildId:   server.Identifier,
+			ChannelId: channelId,
+			Status:    database.DeliveryDelivered,
+		}
+		message, err := session.ChannelMessageSendComplex(channelId, send)
+		if err != nil {
+			delivery.Status = database.DeliveryFailed
+			delivery.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			delivery.MessageId = message.ID
+		}
+
+		for _, entry := range entries[start : start+count] {
+			delivery.ChapterId = entry.chapter.Id
+			saveErr := db.SaveDelivery(delivery)
+			if saveErr != nil {
+				fmt.Println(helpers.FormattedNow(), server.Identifier+":", saveErr.Error())
+			}
+			if err == nil {
+				delivered = append(delivered, entry.chapter)
+			}
+		}
+		start += count
+
+		// Keep the lease alive for long digests
+		db.RenewAnnouncingLease(server.Identifier, owner, announcingLeaseDuration)
+	}
+
+	return delivered, firstErr
+}
+
+// Announces chapters to a guild in digests, in every channel they're routed to, and mentions the subscribers.
+// Channels a chapter has already been delivered to are skipped, like deliverChapter does.
+// Subscribers are mentioned once per channel, for the series of the chapters that weren't announced anywhere before.
+// Cancelling the context stops the digests after the message being sent.
+func deliverDigests(ctx context.Context, db database.Database, session *discordgo.Session, server *types.Server, owner string, routes []types.Route, templates []types.Template, chapters []types.Chapter) error {
+	lang := getLanguage(server, nil)
+
+	// Sort the chapters into the channels they still have to go to
+	var channelIds []string
+	pending := make(map[string][]*types.Chapter)
+	mentioned := make(map[int64]bool)
+	for i := range chapters {
+		chapter := &chapters[i]
+		finished, err := db.GetFinishedDeliveryChannels(server.Identifier, chapter.Id)
+		if err != nil {
+			return err
+		}
+		isFinished := make(map[string]bool)
+		for _, channelId := range finished {
+			isFinished[channelId] = true
+		}
+		mentioned[chapter.Id] = len(finished) > 0
+
+		var chapterChannelIds []string
+		for _, channelId := range routeChapter(server, routes, chapter) {
+			if isFinished[channelId] {
+				continue
+			}
+			if _, ok := pending[channelId]; !ok {
+				channelIds = append(channelIds, channelId)
+			}
+			pending[channelId] = append(pending[channelId], chapter)
+			chapterChannelIds = append(chapterChannelIds, channelId)
+		}
+
+		err = markDeliveriesPending(db, server, chapter, chapterChannelIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	var firstErr error
+	for _, channelId := range channelIds {
+		var titles []string
+		isTitle := make(map[string]bool)
+		for _, group := range groupDigests(server, pending[channelId]) {
+			header := lang.text("digest-daily", len(group))
+			if server.DigestMode == database.DigestSeries {
+				header = lang.text("digest-series", group[0].Manga, len(group))
+			}
+
+			delivered, err := sendDigest(ctx, db, session, server, owner, templates, channelId, header, group)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			for _, chapter := range delivered {
+				if !mentioned[chapter.Id] && !isTitle[chapter.Manga] {
+					isTitle[chapter.Manga] = true
+					titles = append(titles, chapter.Manga)
+				}
+				mentioned[chapter.Id] = true
+			}
+		}
+
+		if len(titles) > 0 {
+			_, err := mentionSubscribers(db, session, server, channelId, titles...)
+			if err != nil {
+				fmt.Println(helpers.FormattedNow(), server.Identifier+":", err.Error())
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return firstErr
+}
+
+// Announces a guild's chapters in digests, then logs the last announcement time and, for daily digests, when it was sent.
+// Nothing is logged if a chapter failed or the digests were cancelled, so the chapters left are retried
+// on the next announcement instead of waiting for the next day.
+func announceDigests(ctx context.Context, db database.Database, session *discordgo.Session, server *types.Server, owner string, routes []types.Route, templates []types.Template, chapters []types.Chapter) error {
+	err := deliverDigests(ctx, db, session, server, owner, routes, templates, chapters)
+	if err != nil {
+		return err
+	}
+
+	var lastLoggedAt time.Time
+	for _, chapter := range chapters {
+		if chapter.LoggedAt.After(lastLoggedAt) {
+			lastLoggedAt = chapter.LoggedAt
+		}
+	}
+	err = db.SetLastAnnouncedTime(server.Identifier, lastLoggedAt)
+	if err != nil {
+		return err
+	}
+
+	if server.DigestMode == database.DigestDaily {
+		return db.SetLastDigestTime(server.Identifier, time.Now())
+	}
+	return nil
+}
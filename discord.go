@@ -3,8 +3,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -41,6 +43,163 @@ func updateResponse(s *discordgo.Session, i *discordgo.Interaction, message stri
 	})
 }
 
+// Reads the "series" or "label" option of a route command into a route for the current channel.
+// Returns false unless exactly one of them is given.
+func getRouteOption(i *discordgo.InteractionCreate) (types.Route, bool) {
+	route := types.Route{
+		GuildId:   i.GuildID,
+		ChannelId: i.ChannelID,
+	}
+
+	options := i.ApplicationCommandData().Options
+	if len(options) != 1 {
+		return route, false
+	}
+
+	switch options[0].Name {
+	case "series":
+		route.Kind = database.RouteSeries
+	case "label":
+		route.Kind = database.RouteLabel
+	default:
+		return route, false
+	}
+	route.Value = options[0].StringValue()
+
+	return route, true
+}
+
+// Gets the options given to a command by their names.
+func getOptions(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range i.ApplicationCommandData().Options {
+		options[option.Name] = option
+	}
+
+	return options
+}
+
+// Reads the options of a template command into a template for the current guild.
+// The embed is shown unless it's turned off. Returns false if no template text is given.
+func getTemplateOption(i *discordgo.InteractionCreate) (types.Template, bool) {
+	options := getOptions(i)
+	template := types.Template{
+		GuildId: i.GuildID,
+		Embed:   true,
+	}
+	if option, ok := options["series"]; ok {
+		template.Series = option.StringValue()
+	}
+	if option, ok := options["embed"]; ok {
+		template.Embed = option.BoolValue()
+	}
+
+	option, ok := options["template"]
+	if ok {
+		template.Text = option.StringValue()
+	}
+	return template, ok
+}
+
+// Checks that a template works by filling it in with a sample chapter, and responds with the problem if it doesn't.
+func checkTemplateOption(s *discordgo.Session, i *discordgo.InteractionCreate, lang *language, server *types.Server, template *types.Template) bool {
+	chapter := makeSampleChapter(template.Series, lang)
+	content, err := renderTemplate(template, server, &chapter)
+	if err != nil {
+		sendEphemeralResponse(s, i, lang.text("invalid-template", err.Error()))
+		return false
+	}
+	if content == "" && !template.Embed {
+		sendEphemeralResponse(s, i, lang.text("template-empty"))
+		return false
+	}
+
+	return true
+}
+
+// Responds with a message followed by how a sample chapter of the template's series would be announced with it.
+// Mentions in the preview don't ping anyone.
+func sendTemplatePreview(s *discordgo.Session, i *discordgo.InteractionCreate, lang *language, server *types.Server, series string, template *types.Template, message string, ephemeral bool) {
+	chapter := makeSampleChapter(series, lang)
+	announcement := makeAnnouncement(server, template, &chapter)
+
+	if announcement.Content != "" {
+		message += "\n\n" + announcement.Content
+	}
+	data := &discordgo.InteractionResponseData{
+		Content:         truncateMessage(message),
+		Embeds:          announcement.Embeds,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if ephemeral {
+		data.Flags = discordgo.MessageFlagsEphemeral
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+}
+
+// Discord refuses messages longer than this.
+const maxMessageLength = 2000
+
+// Cuts a message down to what Discord accepts.
+func truncateMessage(message string) string {
+	return truncateText(message, maxMessageLength)
+}
+
+// Cuts a text down to a number of characters, ending it with an ellipsis if it was cut.
+func truncateText(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	return string(runes[:length-1]) + "…"
+}
+
+// Reloads the targets after they were changed, and responds with the message or with the reload error.
+// The change itself is already saved, so a failed reload only means the scheduler hasn't picked it up yet.
+func respondAfterReloadingTargets(s *discordgo.Session, i *discordgo.InteractionCreate, lang *language, message string) {
+	err := reloadTargets()
+	if err != nil {
+		log.Println(err.Error())
+		sendEphemeralResponse(s, i, message+lang.text("targets-reload-failed", err.Error()))
+		return
+	}
+
+	sendEphemeralResponse(s, i, message)
+}
+
+// Enables or disables the target named in the command.
+func setTargetEnabled(s *discordgo.Session, i *discordgo.InteractionCreate, enabled bool) {
+	lang := getInteractionLanguage(i)
+	if !isAdmin(i) {
+		sendEphemeralResponse(s, i, lang.text("admins-only"))
+		return
+	}
+
+	name := i.ApplicationCommandData().Options[0].StringValue()
+	err := db.SetTargetEnabled(name, enabled)
+	if err != nil {
+		switch err.(type) {
+		case *database.TargetDoesNotExistError:
+			sendEphemeralResponse(s, i, lang.text("target-not-found"))
+			return
+		default:
+			log.Println(err.Error())
+			sendEphemeralResponse(s, i, lang.text("error-target-change"))
+			return
+		}
+	}
+
+	if enabled {
+		respondAfterReloadingTargets(s, i, lang, lang.text("target-enabled", name))
+	} else {
+		respondAfterReloadingTargets(s, i, lang, lang.text("target-disabled", name))
+	}
+}
+
 // Setup commands
 func registerCommands() []*discordgo.ApplicationCommand {
 	// Define commands
@@ -50,9 +209,12 @@ func registerCommands() []*discordgo.ApplicationCommand {
 	commandHandlers := getCommandHandlers()
 
 	// Match the commands and the handlers
+	// Handlers are tracked as in-flight work, so shutdown waits for them (e.g. a manual announcement)
 	session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		if handler, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-			handler(s, i)
+			if !runWork(func(ctx context.Context) { handler(s, i) }) {
+				sendEphemeralResponse(s, i, getInteractionLanguage(i).text("shutting-down"))
+			}
 		}
 	})
 
@@ -79,10 +241,272 @@ func getCommands() []*discordgo.ApplicationCommand {
 			Name:        "announce",
 			Description: "Print all unannounced feed items.",
 		},
+		{
+			Name:        "clear-announcing-flag",
+			Description: "Clear a stuck announcing flag. You must have channel management permissions to do this.",
+		},
 		{
 			Name:        "fetch",
 			Description: "Manually trigger the fetch process for new chapters.",
 		},
+		{
+			Name:        "follow",
+			Description: "Only announce the followed titles here. You must have channel management permissions to do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "title",
+					Description: "The manga title this server should follow.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+			},
+		},
+		{
+			Name:        "unfollow",
+			Description: "Stop following a title in this server. You must have channel management permissions to do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "title",
+					Description: "The manga title this server should stop following.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+			},
+		},
+		{
+			Name:        "following",
+			Description: "List the titles this server follows.",
+		},
+		{
+			Name:        "route",
+			Description: "Route a series or label to this channel. You must have channel management permissions to do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "series",
+					Description: "The manga title to route.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+				{
+					Name:        "label",
+					Description: "The label (tag or genre) to route.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+			},
+		},
+		{
+			Name:        "unroute",
+			Description: "Stop routing a series or label here. You must have channel management permissions to do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "series",
+					Description: "The manga title to stop routing.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+				{
+					Name:        "label",
+					Description: "The label (tag or genre) to stop routing.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    false,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+			},
+		},
+		{
+			Name:        "routes",
+			Description: "List the channels series and labels are routed to in this server.",
+		},
+		{
+			Name:        "target-add",
+			Description: "Add a target for the gofers to fetch. Only bot admins can do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "definition",
+					Description: "The target as a TOML inline table, e.g. name = \"Title\", mode = \"rss\", source = \"https://...\"",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "target-edit",
+			Description: "Replace the definition of a target, keeping its name. Only bot admins can do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "The name of the target",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+				{
+					Name:        "definition",
+					Description: "The new target as a TOML inline table",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "target-enable",
+			Description: "Let the gofers fetch a disabled target again. Only bot admins can do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "The name of the target",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "target-disable",
+			Description: "Stop the gofers from fetching a target. Only bot admins can do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "The name of the target",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "target-remove",
+			Description: "Remove a target. Its chapters are kept. Only bot admins can do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "name",
+					Description: "The name of the target",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "target-list",
+			Description: "List the targets and whether they are enabled.",
+		},
+		{
+			Name:        "set-timezone",
+			Description: "Set this server's timezone. You must have channel management permissions to do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "timezone",
+					Description: "A timezone name like Asia/Tokyo, Europe/London or UTC.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   64,
+				},
+			},
+		},
+		{
+			Name:        "set-language",
+			Description: "Set this server's language. You must have channel management permissions to do this.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "language",
+					Description: "The language to reply in.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					Choices:     getLanguageChoices(),
+				},
+			},
+		},
+		{
+			Name:        "set-digest-mode",
+			Description: "Set whether chapters are announced together. Requires channel management permissions.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "mode",
+					Description: "How the chapters are announced.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "One message per chapter", Value: database.DigestOff},
+						{Name: "One message per series", Value: database.DigestSeries},
+						{Name: "One summary a day", Value: database.DigestDaily},
+					},
+				},
+			},
+		},
+		{
+			Name:        "set-template",
+			Description: "Set how chapters are announced in this server. Requires channel management permissions.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "template",
+					Description: "The message, as a Go template like {{ .Series }} {{ .Number }}: {{ .Url }}",
+					Type:        discordgo.ApplicationCommandOptionString,
+					Required:    true,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   maxMessageLength,
+				},
+				{
+					Name:        "series",
+					Description: "Only use the template for this series.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+				{
+					Name:        "embed",
+					Description: "Whether to send the embed along with the message. It's sent unless you turn it off.",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+				},
+			},
+		},
+		{
+			Name:        "preview-template",
+			Description: "Show how chapters are announced in this server, or how they would be with a template.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "series",
+					Description: "Show how chapters of this series are announced.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+				{
+					Name:        "template",
+					Description: "A template to try out without setting it.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   maxMessageLength,
+				},
+				{
+					Name:        "embed",
+					Description: "Whether to send the embed along with the template you're trying out.",
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+				},
+			},
+		},
+		{
+			Name:        "clear-template",
+			Description: "Announce chapters with the default embed again. Requires channel management permissions.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Name:        "series",
+					Description: "Only clear the template of this series.",
+					Type:        discordgo.ApplicationCommandOptionString,
+					MinLength:   func(i int) *int { return &i }(1),
+					MaxLength:   255,
+				},
+			},
+		},
 		{
 			Name:        "subscribe",
 			Description: "Tells the bot you want to be mentioned whenever a new chapter for a specific manga is announced.",
@@ -118,8 +542,10 @@ func getCommandHandlers() map[string]func(s *discordgo.Session, i *discordgo.Int
 	return map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
 		// Set a channel as the guild's feed channel (also saves the guild into the database)
 		"set-as-feed-channel": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
 			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
-				sendEphemeralResponse(s, i, "You do not have the permission to set the feed channel.")
+				sendEphemeralResponse(s, i, lang.text("no-permission-feed-channel"))
 				return
 			}
 
@@ -127,56 +553,50 @@ func getCommandHandlers() map[string]func(s *discordgo.Session, i *discordgo.Int
 			err = db.SetFeedChannel(i.GuildID, i.ChannelID)
 			if err != nil {
 				log.Println(err.Error())
-				sendEphemeralResponse(s, i, "Something went wrong when setting the feed channel...")
+				sendEphemeralResponse(s, i, lang.text("error-feed-channel-set"))
 				return
 			}
-			sendResponse(s, i, "This channel has been set as the feed channel.")
+			sendResponse(s, i, lang.text("feed-channel-set"))
 		},
 
 		// Manually trigger the announcement for the current guild (Discord server)
 		"announce": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			var isAnnouncing bool
+			lang := getInteractionLanguage(i)
+
 			var err error = nil
-			// Check if the bot is working on announcing the chapters in this guild
-			isAnnouncing, err = db.GetAnnouncingServerFlag(i.GuildID)
+			// Take the announcing lease; stop if another announcer is working on this guild
+			owner := newLeaseOwner()
+			var acquired bool
+			acquired, err = db.AcquireAnnouncingLease(i.GuildID, owner, announcingLeaseDuration)
 			if err != nil {
 				switch err.(type) {
 				case *database.NoFeedChannelSetError:
-					sendEphemeralResponse(s, i, "You have to set the feed channel for this server first.")
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
 					return
 				default:
 					log.Println(err.Error())
-					sendEphemeralResponse(s, i, "Something went wrong when checking the server flags...")
+					sendEphemeralResponse(s, i, lang.text("error-server-flags"))
 					return
 				}
 			}
 
-			if isAnnouncing {
-				sendEphemeralResponse(s, i, "The bot is working, so hold on.")
-				return
-			}
-
-			// Set the "is announcing" flag to true
-			err = db.SetAnnouncingServerFlag(i.GuildID, true)
-			if err != nil {
-				log.Println(err.Error())
-				sendEphemeralResponse(s, i, "Something went wrong when setting the server flags...")
+			if !acquired {
+				sendEphemeralResponse(s, i, lang.text("announcer-busy"))
 				return
 			}
 
-			// Get the feed channel ID
-			var channelId string
-			channelId, err = db.GetFeedChannel(i.GuildID)
+			// Get the feed channel ID and the settings of the guild
+			server, err := db.GetServer(i.GuildID)
 			if err != nil {
 				var nf *database.NoFeedChannelSetError
 				if errors.As(err, &nf) {
-					sendEphemeralResponse(s, i, "You have to set the feed channel for this server first.")
-					db.SetAnnouncingServerFlag(i.GuildID, false)
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					db.ReleaseAnnouncingLease(i.GuildID, owner)
 					return
 				}
 				log.Println(err.Error())
-				sendEphemeralResponse(s, i, "Something went wrong when getting the feed channel...")
-				db.SetAnnouncingServerFlag(i.GuildID, false)
+				sendEphemeralResponse(s, i, lang.text("error-feed-channel-get"))
+				db.ReleaseAnnouncingLease(i.GuildID, owner)
 				return
 			}
 
@@ -185,120 +605,634 @@ func getCommandHandlers() map[string]func(s *discordgo.Session, i *discordgo.Int
 			if err != nil {
 				var nf *database.NoFeedChannelSetError
 				if errors.As(err, &nf) {
-					sendEphemeralResponse(s, i, "You have to set the feed channel for this server first.")
-					db.SetAnnouncingServerFlag(i.GuildID, false)
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					db.ReleaseAnnouncingLease(i.GuildID, owner)
 					return
 				}
 				log.Println(err.Error())
-				sendEphemeralResponse(s, i, "Something went wrong when fetching the chapters...")
-				db.SetAnnouncingServerFlag(i.GuildID, false)
+				sendEphemeralResponse(s, i, lang.text("error-chapters-get"))
+				db.ReleaseAnnouncingLease(i.GuildID, owner)
 				return
 			}
 
-			if len(*chapters) > 0 {
-				// Say that chapters are found
-				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "Chapters found. Announcing...",
-						Flags:   discordgo.MessageFlagsEphemeral,
-					},
-				})
+			// Get where the chapters should go
+			routes, err := db.GetRoutes(i.GuildID)
+			if err != nil {
+				log.Println(err.Error())
+				sendEphemeralResponse(s, i, lang.text("error-routes-get"))
+				db.ReleaseAnnouncingLease(i.GuildID, owner)
+				return
+			}
 
-				// Send all the chapters
-				var server = types.Server{
-					Identifier:            i.GuildID,
-					FeedChannelIdentifier: channelId,
-				}
-				botched := false
-				var lastLoggedAt time.Time
-				for _, chapter := range *chapters {
-					_, err = announceChapter(s, &server, &chapter)
-					if err != nil {
-						log.Println(server.Identifier+":", err.Error())
-						updateResponse(s, i.Interaction, "Something went wrong when announcing a chapter...")
-						db.SetAnnouncingServerFlag(i.GuildID, false)
-						botched = true
-						break
-					}
-
-					_, err = mentionSubscribers(db, s, &server, &chapter)
-					if err != nil {
-						log.Println(server.Identifier+":", err.Error())
-					}
-
-					lastLoggedAt = chapter.LoggedAt
-				}
+			// Get how the chapters should be announced
+			templates, err := db.GetTemplates(i.GuildID)
+			if err != nil {
+				log.Println(err.Error())
+				sendEphemeralResponse(s, i, lang.text("error-templates-get"))
+				db.ReleaseAnnouncingLease(i.GuildID, owner)
+				return
+			}
 
-				err = db.SetLastAnnouncedTime(i.GuildID, lastLoggedAt)
-				if err != nil {
-					log.Println(err.Error())
-					sendEphemeralResponse(s, i, "Something went wrong when setting the last announcement timestamp...")
-				}
+			if len(*chapters) > 0 {
+				sendEphemeralResponse(s, i, lang.text("announcing"))
 
-				if !botched {
-					updateResponse(s, i.Interaction, "Announcing finished.")
+				// Digests are sent right away, even if today's has already been sent
+				err = announceChapters(appContext, db, s, &server, owner, routes, templates, *chapters)
+				if appContext.Err() != nil {
+					updateResponse(s, i.Interaction, lang.text("announcing-interrupted"))
+				} else if err != nil {
+					log.Println(server.Identifier+":", err.Error())
+					updateResponse(s, i.Interaction, lang.text("error-announcing"))
+				} else {
+					updateResponse(s, i.Interaction, lang.text("announcing-finished"))
 				}
 			} else {
-				sendEphemeralResponse(s, i, "There are no new chapters to announce.")
+				sendEphemeralResponse(s, i, lang.text("no-new-chapters"))
 			}
 
-			// Clear the "is announcing" flag back to false
-			err = db.SetAnnouncingServerFlag(i.GuildID, false)
+			// Give the announcing lease back; the interaction has been answered by now, so the error replaces the answer
+			err = db.ReleaseAnnouncingLease(i.GuildID, owner)
 			if err != nil {
 				log.Println(err.Error())
-				sendEphemeralResponse(s, i, "Something went wrong when clearing the server flag...")
+				updateResponse(s, i.Interaction, lang.text("error-server-flag-clear"))
 				return
 			}
 		},
 
-		// Manually trigger the gofers
-		"fetch": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			if currentlyFetchingTargets {
-				sendEphemeralResponse(s, i, "The fetch process is currently in progress.")
+		// Forcefully clear the announcing lease of the current guild, in case it got stuck
+		"clear-announcing-flag": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-announcing-flag"))
 				return
 			}
 
-			go startGofers(db, &config.Targets)
-			sendEphemeralResponse(s, i, "Started the fetch process.")
-		},
-
-		// Add a user and a specified manga title to the subscribe list
-		"subscribe": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-			title := i.ApplicationCommandData().Options[0].StringValue()
-			err := db.SaveSubscription(i.Member.User.ID, i.GuildID, title)
+			err := db.ClearAnnouncingLease(i.GuildID)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-server-flag-clear"))
+					return
+				}
+			}
+
+			sendEphemeralResponse(s, i, lang.text("announcing-flag-cleared"))
+		},
+
+		// Manually trigger the gofers
+		"fetch": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if isFetchingAllTargets() {
+				sendEphemeralResponse(s, i, lang.text("fetch-in-progress"))
+				return
+			}
+
+			if !goWork(func(ctx context.Context) {
+				targets := getTargets()
+				startGofers(ctx, db, &targets)
+			}) {
+				sendEphemeralResponse(s, i, lang.text("shutting-down"))
+				return
+			}
+			sendEphemeralResponse(s, i, lang.text("fetch-started"))
+		},
+
+		// Add a manga title to the guild's follow list
+		"follow": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-follows"))
+				return
+			}
+
+			title := i.ApplicationCommandData().Options[0].StringValue()
+			err := db.FollowManga(i.GuildID, title)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				case *database.TitleDoesNotExistError:
+					sendEphemeralResponse(s, i, lang.text("title-not-found"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-follow"))
+					return
+				}
+			}
+
+			sendResponse(s, i, lang.text("followed", title))
+		},
+
+		// Remove a manga title from the guild's follow list
+		"unfollow": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-follows"))
+				return
+			}
+
+			title := i.ApplicationCommandData().Options[0].StringValue()
+			err := db.UnfollowManga(i.GuildID, title)
+			if err != nil {
+				switch err.(type) {
+				case *database.NotFollowingError:
+					sendEphemeralResponse(s, i, lang.text("not-following"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-unfollow"))
+					return
+				}
+			}
+
+			sendResponse(s, i, lang.text("unfollowed", title))
+		},
+
+		// List the guild's followed manga titles
+		"following": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			titles, err := db.GetFollowedManga(i.GuildID)
+			if err != nil {
+				log.Println(err.Error())
+				sendEphemeralResponse(s, i, lang.text("error-following-get"))
+				return
+			}
+
+			if len(titles) < 1 {
+				sendEphemeralResponse(s, i, lang.text("following-everything"))
+				return
+			}
+
+			sendEphemeralResponse(s, i, lang.text("following", "["+strings.Join(titles, "], [")+"]"))
+		},
+
+		// Route a series or a label to the current channel
+		"route": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-routes"))
+				return
+			}
+
+			route, ok := getRouteOption(i)
+			if !ok {
+				sendEphemeralResponse(s, i, lang.text("route-option-required"))
+				return
+			}
+
+			err := db.AddRoute(route)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-route-add"))
+					return
+				}
+			}
+
+			sendResponse(s, i, lang.text("routed", lang.text("route-kind-"+route.Kind), route.Value))
+		},
+
+		// Remove a route of a series or a label to the current channel
+		"unroute": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-routes"))
+				return
+			}
+
+			route, ok := getRouteOption(i)
+			if !ok {
+				sendEphemeralResponse(s, i, lang.text("route-option-required"))
+				return
+			}
+
+			err := db.RemoveRoute(route)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoRouteFoundError:
+					sendEphemeralResponse(s, i, lang.text("route-not-found"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-route-remove"))
+					return
+				}
+			}
+
+			sendResponse(s, i, lang.text("unrouted", lang.text("route-kind-"+route.Kind), route.Value))
+		},
+
+		// List the guild's routes
+		"routes": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			routes, err := db.GetRoutes(i.GuildID)
+			if err != nil {
+				log.Println(err.Error())
+				sendEphemeralResponse(s, i, lang.text("error-routes-get"))
+				return
+			}
+
+			if len(routes) < 1 {
+				sendEphemeralResponse(s, i, lang.text("no-routes"))
+				return
+			}
+
+			lines := make([]string, 0, len(routes))
+			for _, route := range routes {
+				lines = append(lines, lang.text("route", lang.text("route-kind-"+route.Kind), route.Value, route.ChannelId))
+			}
+			sendEphemeralResponse(s, i, strings.Join(lines, "\n"))
+		},
+
+		// Add a target from its TOML definition
+		"target-add": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if !isAdmin(i) {
+				sendEphemeralResponse(s, i, lang.text("admins-only"))
+				return
+			}
+
+			target, err := parseTargetDefinition(i.ApplicationCommandData().Options[0].StringValue())
+			if err != nil {
+				sendEphemeralResponse(s, i, lang.text("invalid-target-definition", err.Error()))
+				return
+			}
+
+			err = db.AddTarget(target)
+			if err != nil {
+				switch err.(type) {
+				case *database.TargetAlreadyExistsError:
+					sendEphemeralResponse(s, i, lang.text("target-exists"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-target-add"))
+					return
+				}
+			}
+
+			respondAfterReloadingTargets(s, i, lang, lang.text("target-added", target.Name))
+		},
+
+		// Replace a target's definition
+		"target-edit": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if !isAdmin(i) {
+				sendEphemeralResponse(s, i, lang.text("admins-only"))
+				return
+			}
+
+			options := i.ApplicationCommandData().Options
+			name := options[0].StringValue()
+			target, err := parseTargetDefinition(options[1].StringValue())
+			if err != nil {
+				sendEphemeralResponse(s, i, lang.text("invalid-target-definition", err.Error()))
+				return
+			}
+
+			err = db.UpdateTarget(name, target)
+			if err != nil {
+				switch err.(type) {
+				case *database.TargetDoesNotExistError:
+					sendEphemeralResponse(s, i, lang.text("target-not-found"))
+					return
+				case *database.TargetAlreadyExistsError:
+					sendEphemeralResponse(s, i, lang.text("target-exists"))
+					return
+				case *database.TargetRenameError:
+					sendEphemeralResponse(s, i, lang.text("target-rename"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-target-edit"))
+					return
+				}
+			}
+
+			respondAfterReloadingTargets(s, i, lang, lang.text("target-updated", name))
+		},
+
+		// Enable a target
+		"target-enable": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			setTargetEnabled(s, i, true)
+		},
+
+		// Disable a target
+		"target-disable": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			setTargetEnabled(s, i, false)
+		},
+
+		// Remove a target
+		"target-remove": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if !isAdmin(i) {
+				sendEphemeralResponse(s, i, lang.text("admins-only"))
+				return
+			}
+
+			name := i.ApplicationCommandData().Options[0].StringValue()
+			entry, err := db.GetTarget(name)
+			if err == nil {
+				err = db.RemoveTarget(name)
+			}
+			if err != nil {
+				switch err.(type) {
+				case *database.TargetDoesNotExistError:
+					sendEphemeralResponse(s, i, lang.text("target-not-found"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-target-remove"))
+					return
+				}
+			}
+
+			message := lang.text("target-removed", name)
+			if entry.Origin == database.TargetFromConfig {
+				message += lang.text("target-still-in-config")
+			}
+			respondAfterReloadingTargets(s, i, lang, message)
+		},
+
+		// List the targets
+		"target-list": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			server := getInteractionServer(i)
+			lang := getLanguage(server, i.GuildLocale)
+
+			entries, err := db.GetTargets()
+			if err != nil {
+				log.Println(err.Error())
+				sendEphemeralResponse(s, i, lang.text("error-targets-get"))
+				return
+			}
+
+			if len(entries) < 1 {
+				sendEphemeralResponse(s, i, lang.text("no-targets"))
+				return
+			}
+
+			lines := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				lines = append(lines, describeTarget(entry, lang, getTimezone(server)))
+			}
+			sendEphemeralResponse(s, i, truncateMessage(strings.Join(lines, "\n")))
+		},
+
+		// Set the timezone of the guild's dates
+		"set-timezone": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-settings"))
+				return
+			}
+
+			timezone := i.ApplicationCommandData().Options[0].StringValue()
+			location, err := time.LoadLocation(timezone)
+			if err != nil || timezone == "" || timezone == "Local" {
+				sendEphemeralResponse(s, i, lang.text("invalid-timezone", timezone))
+				return
+			}
+
+			err = db.SetServerTimezone(i.GuildID, location.String())
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-timezone-set"))
+					return
+				}
+			}
+
+			sendResponse(s, i, lang.text("timezone-set", location.String(), lang.formatDate(time.Now(), location)))
+		},
+
+		// Set the language of the bot's replies in the guild
+		"set-language": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-settings"))
+				return
+			}
+
+			code := i.ApplicationCommandData().Options[0].StringValue()
+			chosen := findLanguage(code)
+			if chosen == nil {
+				sendEphemeralResponse(s, i, lang.text("invalid-language", code))
+				return
+			}
+
+			err := db.SetServerLanguage(i.GuildID, chosen.Code)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-language-set"))
+					return
+				}
+			}
+
+			// Answer in the new language
+			sendResponse(s, i, chosen.text("language-set"))
+		},
+
+		// Set whether the guild's chapters are announced together
+		"set-digest-mode": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-settings"))
+				return
+			}
+
+			mode := i.ApplicationCommandData().Options[0].StringValue()
+			if mode != database.DigestOff && mode != database.DigestSeries && mode != database.DigestDaily {
+				sendEphemeralResponse(s, i, lang.text("invalid-digest-mode", mode))
+				return
+			}
+
+			err := db.SetServerDigestMode(i.GuildID, mode)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-digest-mode-set"))
+					return
+				}
+			}
+
+			sendResponse(s, i, lang.text("digest-mode-set-"+mode))
+		},
+
+		// Set the template chapters are announced with in the guild, or for one of its series
+		"set-template": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-settings"))
+				return
+			}
+
+			template, _ := getTemplateOption(i)
+			server := getInteractionServer(i)
+			if !checkTemplateOption(s, i, lang, server, &template) {
+				return
+			}
+
+			err := db.SetTemplate(template)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoFeedChannelSetError:
+					sendEphemeralResponse(s, i, lang.text("no-feed-channel"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-template-set"))
+					return
+				}
+			}
+
+			message := lang.text("template-set")
+			if template.Series != "" {
+				message = lang.text("template-set-series", template.Series)
+			}
+			sendTemplatePreview(s, i, lang, server, template.Series, &template, message, false)
+		},
+
+		// Show how chapters are announced in the guild, or would be with a template
+		"preview-template": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+			server := getInteractionServer(i)
+
+			template, given := getTemplateOption(i)
+			if given {
+				if !checkTemplateOption(s, i, lang, server, &template) {
+					return
+				}
+				sendTemplatePreview(s, i, lang, server, template.Series, &template, lang.text("template-preview-draft"), true)
+				return
+			}
+
+			templates, err := db.GetTemplates(i.GuildID)
+			if err != nil {
+				log.Println(err.Error())
+				sendEphemeralResponse(s, i, lang.text("error-templates-get"))
+				return
+			}
+
+			// Show the template that's set, so it can be copied and edited
+			current := findTemplate(templates, template.Series)
+			if current == nil {
+				sendTemplatePreview(s, i, lang, server, template.Series, nil, lang.text("template-preview-default"), true)
+				return
+			}
+			if !checkTemplateOption(s, i, lang, server, current) {
+				return
+			}
+			sendTemplatePreview(s, i, lang, server, template.Series, current, lang.text("template-preview", current.Text), true)
+		},
+
+		// Clear the template of the guild, or of one of its series
+		"clear-template": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			if i.Member.Permissions&discordgo.PermissionManageChannels == 0 {
+				sendEphemeralResponse(s, i, lang.text("no-permission-settings"))
+				return
+			}
+
+			template, _ := getTemplateOption(i)
+			err := db.RemoveTemplate(i.GuildID, template.Series)
+			if err != nil {
+				switch err.(type) {
+				case *database.NoTemplateFoundError:
+					sendEphemeralResponse(s, i, lang.text("template-not-found"))
+					return
+				default:
+					log.Println(err.Error())
+					sendEphemeralResponse(s, i, lang.text("error-template-remove"))
+					return
+				}
+			}
+
+			if template.Series != "" {
+				sendResponse(s, i, lang.text("template-cleared-series", template.Series))
+				return
+			}
+			sendResponse(s, i, lang.text("template-cleared"))
+		},
+
+		// Add a user and a specified manga title to the subscribe list
+		"subscribe": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
+			title := i.ApplicationCommandData().Options[0].StringValue()
+			err := db.SaveSubscription(i.Member.User.ID, i.GuildID, title)
 			if err != nil {
 				switch err.(type) {
 				case *database.TitleDoesNotExistError:
-					sendEphemeralResponse(s, i, "That title does not exist.")
+					sendEphemeralResponse(s, i, lang.text("title-not-found"))
 					return
 				default:
 					log.Println(err.Error())
-					sendEphemeralResponse(s, i, "Something went wrong when trying to subscribe you...")
+					sendEphemeralResponse(s, i, lang.text("error-subscribe"))
 					return
 				}
 			}
 
-			sendEphemeralResponse(s, i, "You are now subscribed to ["+title+"].")
+			sendEphemeralResponse(s, i, lang.text("subscribed", title))
 		},
 
 		// Add a user and a specified manga title to the subscribe list
 		"unsubscribe": func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			lang := getInteractionLanguage(i)
+
 			title := i.ApplicationCommandData().Options[0].StringValue()
 			err := db.RemoveSubscription(i.Member.User.ID, i.GuildID, title)
 			if err != nil {
 				switch err.(type) {
 				case *database.NoSubscriptionFoundError:
-					sendEphemeralResponse(s, i, "You are not subscribed to that title.")
+					sendEphemeralResponse(s, i, lang.text("not-subscribed"))
 					return
 				default:
 					log.Println(err.Error())
-					sendEphemeralResponse(s, i, "Something went wrong when trying to subscribe you...")
+					sendEphemeralResponse(s, i, lang.text("error-unsubscribe"))
 					return
 				}
 			}
 
-			sendEphemeralResponse(s, i, "You are no longer subscribed to ["+title+"].")
+			sendEphemeralResponse(s, i, lang.text("unsubscribed", title))
 		},
 	}
 }
@@ -0,0 +1,256 @@
+// This file handles how gofers are allowed to hit the sources:
+// how many requests can go to the same host at once, how far apart they are,
+// and how long to wait before retrying a failed fetch.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+type fetchConfiguration struct {
+	MaxAttempts     int    // How many times a fetch is tried before giving up
+	HostConcurrency int    // How many requests can be made to the same host at once
+	HostSpacing     string // Minimum time between two requests to the same host, e.g. "2s"
+	BackoffBase     string // Delay before the first retry; doubled on every retry, e.g. "5s"
+	BackoffMax      string // Maximum delay between retries, e.g. "5m"
+}
+
+// Parses a duration from the config, or returns the fallback if it's empty or invalid.
+func parseDurationOr(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return fallback
+	}
+
+	return duration
+}
+
+// Gets the fetch configuration with defaults filled in.
+func getFetchConfiguration() fetchConfiguration {
+	fetch := getConfig().Fetch
+	if fetch.MaxAttempts < 1 {
+		fetch.MaxAttempts = 5
+	}
+	if fetch.HostConcurrency < 1 {
+		fetch.HostConcurrency = 1
+	}
+
+	return fetch
+}
+
+// This error is thrown when a source responds with an unsuccessful status code.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // How long the source asked us to wait, if it did
+}
+
+func (e *StatusError) Error() string {
+	return "The source responded with " + strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+}
+
+// This error wraps errors that won't go away by retrying, like a 404 or a parse error.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Turns an unsuccessful response into a StatusError,
+// marking it as permanent if retrying won't help.
+func newStatusError(response *http.Response) error {
+	statusError := &StatusError{
+		StatusCode: response.StatusCode,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+
+	switch response.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return statusError
+	}
+	if response.StatusCode >= 400 && response.StatusCode < 500 {
+		return &PermanentError{Err: statusError}
+	}
+
+	return statusError
+}
+
+// Parses the Retry-After header, which is either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// Calculates how long to wait before the given retry (starting from 1).
+// The delay doubles on every retry up to the maximum, and is then jittered
+// so targets that failed together don't retry together.
+func backoffDelay(retry int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < retry && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	// Pick somewhere between half and the full delay
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Limits the requests made to a single host.
+type hostLimiter struct {
+	slots chan struct{}
+	lock  sync.Mutex
+	next  time.Time // The earliest time the next request can be made
+}
+
+var hostLimiters = make(map[string]*hostLimiter)
+var hostLimitersLock sync.Mutex
+
+// Gets the limiter for the host of the given URL.
+func getHostLimiter(source string) *hostLimiter {
+	host := source
+	if parsed, err := url.Parse(source); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+
+	limiter, ok := hostLimiters[host]
+	if !ok {
+		limiter = &hostLimiter{slots: make(chan struct{}, getFetchConfiguration().HostConcurrency)}
+		hostLimiters[host] = limiter
+	}
+
+	return limiter
+}
+
+// Forgets the limiters, so they're made again with the current settings.
+// Requests already holding a slot of an old limiter are unaffected.
+func resetHostLimiters() {
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+
+	hostLimiters = make(map[string]*hostLimiter)
+}
+
+// Waits until a request can be made to the host, and returns a function to call when it's done.
+// Returns an error instead if the context is cancelled while waiting.
+func (l *hostLimiter) acquire(ctx context.Context, spacing time.Duration) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-l.slots }
+
+	l.lock.Lock()
+	wait := time.Until(l.next)
+	if wait < 0 {
+		wait = 0
+	}
+	l.next = time.Now().Add(wait + spacing)
+	l.lock.Unlock()
+
+	if !sleepContext(ctx, wait) {
+		release()
+		return nil, ctx.Err()
+	}
+
+	return release, nil
+}
+
+// Fetches and parses a target, retrying with backoff when the error is worth retrying.
+// It returns the result of the last attempt.
+// It stops early if the context is cancelled.
+func fetchChaptersWithRetry(ctx context.Context, target *types.Target, cache *types.FetchCache) ([]types.Chapter, fetchResponse, error) {
+	fetch := getFetchConfiguration()
+	spacing := parseDurationOr(fetch.HostSpacing, 2*time.Second)
+	base := parseDurationOr(fetch.BackoffBase, 5*time.Second)
+	max := parseDurationOr(fetch.BackoffMax, 5*time.Minute)
+	limiter := getHostLimiter(target.Source)
+
+	var chapters []types.Chapter
+	var response fetchResponse
+	var err error
+	for attempt := 1; attempt <= fetch.MaxAttempts; attempt++ {
+		release, acquireErr := limiter.acquire(ctx, spacing)
+		if acquireErr != nil {
+			return nil, response, acquireErr
+		}
+		chapters, response, err = fetchChapters(ctx, target, cache)
+		release()
+		if err == nil {
+			return chapters, response, nil
+		}
+		if ctx.Err() != nil {
+			return nil, response, ctx.Err()
+		}
+
+		var permanent *PermanentError
+		if errors.As(err, &permanent) {
+			fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed fetching:", err.Error(), "| Not retrying")
+			return chapters, response, err
+		}
+		if attempt == fetch.MaxAttempts {
+			break
+		}
+
+		delay := backoffDelay(attempt, base, max)
+		var statusError *StatusError
+		if errors.As(err, &statusError) && statusError.RetryAfter > delay {
+			// Give up for now if the source wants us gone for longer than we're willing to wait
+			if statusError.RetryAfter > max {
+				fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed fetching:", err.Error(), "| Source asked to retry after", statusError.RetryAfter.Round(time.Second), "| Not retrying")
+				return chapters, response, err
+			}
+			delay = statusError.RetryAfter
+		}
+
+		fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed fetching:", err.Error(), "| Retrying in", delay.Round(time.Second), "| Remaining attempt(s):", fetch.MaxAttempts-attempt)
+		if !sleepContext(ctx, delay) {
+			return nil, response, ctx.Err()
+		}
+	}
+
+	return chapters, response, err
+}
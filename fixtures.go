@@ -0,0 +1,209 @@
+// This file handles fixtures: responses of the sources recorded to files,
+// so the targets can be parsed again later without the network and compared with what they found when recorded.
+// Every target has two files in the fixtures directory, named after it:
+// the raw response (.body) and the chapters parsed from it (.json).
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// The chapters parsed from a recorded response.
+type fixtureSnapshot struct {
+	Target   string
+	Mode     string
+	Chapters []fixtureChapter
+}
+
+// A parsed chapter as it's kept in a snapshot.
+// Values that fell back to a default are left empty, since they change every time (e.g. a date that's the fetch time).
+type fixtureChapter struct {
+	Number    string
+	Title     string
+	Date      string
+	Url       string
+	Defaulted []string `json:",omitempty"`
+
+	Thumbnail   string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Author      string `json:",omitempty"`
+	Access      string `json:",omitempty"`
+}
+
+// Gets the base file name of a target's fixture, made from its name.
+func getFixtureName(target string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, target)
+
+	return strings.Trim(name, "-")
+}
+
+// Gets the paths of a target's recorded response and snapshot.
+func getFixturePaths(directory string, target string) (string, string) {
+	base := filepath.Join(directory, getFixtureName(target))
+	return base + ".body", base + ".json"
+}
+
+// Turns parsed chapters into a snapshot.
+func makeFixtureSnapshot(target *types.Target, chapters []types.Chapter) fixtureSnapshot {
+	snapshot := fixtureSnapshot{
+		Target:   target.Name,
+		Mode:     target.Mode,
+		Chapters: make([]fixtureChapter, 0, len(chapters)),
+	}
+
+	for _, chapter := range chapters {
+		defaulted := make(map[string]bool)
+		for _, field := range chapter.Defaulted {
+			defaulted[field] = true
+		}
+		keep := func(field string, value string) string {
+			if defaulted[field] {
+				return ""
+			}
+			return value
+		}
+
+		snapshot.Chapters = append(snapshot.Chapters, fixtureChapter{
+			Number:    keep("Number", chapter.Number),
+			Title:     keep("Title", chapter.Title),
+			Date:      keep("Date", chapter.Date.Format(time.RFC3339)),
+			Url:       keep("Url", chapter.Url),
+			Defaulted: chapter.Defaulted,
+
+			Thumbnail:   chapter.Thumbnail,
+			Description: chapter.Description,
+			Author:      chapter.Author,
+			Access:      chapter.Access,
+		})
+	}
+
+	return snapshot
+}
+
+// Fetches a target's source and saves the response and the chapters parsed from it as its fixture.
+// The response is saved even if it can't be parsed, but the snapshot isn't.
+func recordFixture(ctx context.Context, directory string, target *types.Target) (int, error) {
+	settings := getTargetHttpConfig(target)
+	client, err := getHttpClient(settings)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := fetchBody(ctx, client, settings.UserAgent, target.Source, target.RequestHeaders, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	bodyPath, snapshotPath := getFixturePaths(directory, target.Name)
+	err = os.WriteFile(bodyPath, []byte(response.Body), 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	chapters, _, err := parseChapters(target, &response.Body)
+	if err != nil {
+		return 0, fmt.Errorf("the response was saved, but could not be parsed: %w", err)
+	}
+
+	return len(chapters), writeFixtureSnapshot(snapshotPath, makeFixtureSnapshot(target, chapters))
+}
+
+func writeFixtureSnapshot(path string, snapshot fixtureSnapshot) error {
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// This error is returned when a target has no recorded response.
+type NoFixtureError struct{}
+
+func (e *NoFixtureError) Error() string {
+	return "No fixture has been recorded for this target"
+}
+
+// Parses a target's recorded response.
+func replayFixture(directory string, target *types.Target) ([]types.Chapter, error) {
+	bodyPath, _ := getFixturePaths(directory, target.Name)
+	content, err := os.ReadFile(bodyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &NoFixtureError{}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	body := string(content)
+	chapters, _, err := parseChapters(target, &body)
+	return chapters, err
+}
+
+// Parses a target's recorded response and compares the chapters with its snapshot.
+// Returns the differences found, if any.
+func checkFixture(directory string, target *types.Target) ([]string, error) {
+	chapters, err := replayFixture(directory, target)
+	if err != nil {
+		return nil, err
+	}
+
+	_, snapshotPath := getFixturePaths(directory, target.Name)
+	content, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	var expected fixtureSnapshot
+	err = json.Unmarshal(content, &expected)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", snapshotPath, err)
+	}
+
+	return compareFixtureSnapshots(expected, makeFixtureSnapshot(target, chapters)), nil
+}
+
+// Lists the differences between the expected and the actual snapshot.
+func compareFixtureSnapshots(expected fixtureSnapshot, actual fixtureSnapshot) []string {
+	var differences []string
+
+	if len(expected.Chapters) != len(actual.Chapters) {
+		differences = append(differences, fmt.Sprintf("expected %d chapter(s), found %d", len(expected.Chapters), len(actual.Chapters)))
+	}
+
+	for index := 0; index < len(expected.Chapters) && index < len(actual.Chapters); index++ {
+		e := expected.Chapters[index]
+		a := actual.Chapters[index]
+		for _, field := range []struct{ name, expected, actual string }{
+			{"Number", e.Number, a.Number},
+			{"Title", e.Title, a.Title},
+			{"Date", e.Date, a.Date},
+			{"Url", e.Url, a.Url},
+			{"Thumbnail", e.Thumbnail, a.Thumbnail},
+			{"Description", e.Description, a.Description},
+			{"Author", e.Author, a.Author},
+			{"Access", e.Access, a.Access},
+		} {
+			if field.expected != field.actual {
+				differences = append(differences, fmt.Sprintf("chapter %d: %s: expected %q, found %q", index+1, field.name, field.expected, field.actual))
+			}
+		}
+	}
+
+	return differences
+}
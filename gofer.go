@@ -3,6 +3,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -19,111 +21,267 @@ import (
 // To make sure not more than one gofer is running for one target,
 // this flag is raised to "true" when startGofers is called,
 // and startGofers can't run unless it's set to false.
+// It's checked from the cronjob, the web interface and Discord at once, so it's only used through the functions below.
 var currentlyFetchingTargets = false
+var currentlyFetchingTargetsLock sync.Mutex
+
+// Raises the fetching flag for every target. Returns false if it was already up.
+func claimAllTargets() bool {
+	currentlyFetchingTargetsLock.Lock()
+	defer currentlyFetchingTargetsLock.Unlock()
+
+	if currentlyFetchingTargets {
+		return false
+	}
+	currentlyFetchingTargets = true
+	return true
+}
+
+// Takes down the fetching flag for every target.
+func releaseAllTargets() {
+	currentlyFetchingTargetsLock.Lock()
+	defer currentlyFetchingTargetsLock.Unlock()
+
+	currentlyFetchingTargets = false
+}
+
+// Checks if the fetching flag for every target is up.
+func isFetchingAllTargets() bool {
+	currentlyFetchingTargetsLock.Lock()
+	defer currentlyFetchingTargetsLock.Unlock()
+
+	return currentlyFetchingTargets
+}
+
+// Same as above, but for a single target.
+// Targets with their own schedule can be fetched at any time,
+// so this makes sure two gofers never work on the same target simultaneously.
+var fetchingTargets = make(map[string]bool)
+var fetchingTargetsLock sync.Mutex
+
+// Raises the fetching flag for a target. Returns false if it was already up.
+func claimTarget(name string) bool {
+	fetchingTargetsLock.Lock()
+	defer fetchingTargetsLock.Unlock()
+
+	if fetchingTargets[name] {
+		return false
+	}
+	fetchingTargets[name] = true
+	return true
+}
+
+// Takes down the fetching flag for a target.
+func releaseTarget(name string) {
+	fetchingTargetsLock.Lock()
+	defer fetchingTargetsLock.Unlock()
+
+	delete(fetchingTargets, name)
+}
 
 // This is just a custom error that's thrown whenever
-// startGofers() is called when the above flag is still up.
+// startGofers() is called when the above flags are still up.
 type PreoccupiedError struct{}
 
 func (e *PreoccupiedError) Error() string {
 	return "The gofer is not done fetching yet"
 }
 
+// The result of fetching a source.
+type fetchResponse struct {
+	Body          string
+	StatusCode    int
+	NotModified   bool             // The source responded with 304, so Body is empty
+	Cache         types.FetchCache // The validators sent by the source, to be used on the next request
+	ChaptersFound int              // How many chapters the body had before the target's filters
+}
+
 // This turns a source URL into a string containing the response body.
-func fetchBody(url string, headers map[string]string) (string, error) {
-	request, err := http.NewRequest("GET", url, nil)
+// If a cache is given, the request is made conditional with its ETag and Last-Modified values,
+// and a 304 response is reported through NotModified instead of a body.
+func fetchBody(ctx context.Context, client *http.Client, userAgent string, url string, headers map[string]string, cache *types.FetchCache) (fetchResponse, error) {
+	var result fetchResponse
+
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
+	if userAgent != "" {
+		request.Header.Set("User-Agent", userAgent)
+	}
 	for key, value := range headers {
 		request.Header.Set(key, value)
 	}
 
-	response, err := http.DefaultClient.Do(request)
+	if cache != nil {
+		if cache.ETag != "" {
+			request.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			request.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	response, err := client.Do(request)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 	defer response.Body.Close()
 
+	result.StatusCode = response.StatusCode
+	if response.StatusCode == http.StatusNotModified {
+		result.NotModified = true
+		if cache != nil {
+			result.Cache = *cache
+		}
+		return result, nil
+	}
+	if response.StatusCode >= 400 {
+		return result, newStatusError(response)
+	}
+
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		return "", err
+		return result, err
+	}
+
+	result.Body = string(body)
+	result.Cache = types.FetchCache{
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
 	}
 
-	return string(body), nil
+	return result, nil
 }
 
-// This fetches the source and then parses it according to the specified mode.
-func fetchChapters(target *types.Target) ([]types.Chapter, error) {
-	var body string
+// This parses a source's body according to the target's mode, and drops the chapters the target's filters don't let through.
+// It also returns how many chapters there were before filtering.
+func parseChapters(target *types.Target, body *string) ([]types.Chapter, int, error) {
+	// The parsers apply the filters themselves, so they're given the target without them
+	unfiltered := *target
+	unfiltered.Filters = types.Filters{}
+
 	var chapters []types.Chapter
 	var err error
-
 	if target.Mode == "json" {
-		body, err = fetchBody(target.Source, target.RequestHeaders)
-		if err != nil {
-			return nil, err
-		}
-		chapters, err = parsers.ParseJson(target, &body)
-		if err != nil {
-			return nil, err
-		}
+		chapters, err = parsers.ParseJson(&unfiltered, body)
 	} else if target.Mode == "rss" {
-		body, err = fetchBody(target.Source, target.RequestHeaders)
-		if err != nil {
-			return nil, err
-		}
-		chapters, err = parsers.ParseRss(target, &body)
-		if err != nil {
-			return nil, err
-		}
+		chapters, err = parsers.ParseRss(&unfiltered, body)
 	} else if target.Mode == "html" {
-		body, err = fetchBody(target.Source, target.RequestHeaders)
-		if err != nil {
-			return nil, err
-		}
-		chapters, err = parsers.ParseHtml(target, &body)
-		if err != nil {
-			return nil, err
-		}
+		chapters, err = parsers.ParseHtml(&unfiltered, body)
+	} else {
+		err = errors.New("unknown mode: " + target.Mode)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	found := len(chapters)
+	chapters, err = parsers.FilterChapters(target, chapters)
+	return chapters, found, err
+}
+
+// This fetches the source and then parses it according to the specified mode.
+// If the source reports that it has not been modified since the cached response, parsing is skipped
+// and the returned response has NotModified set.
+func fetchChapters(ctx context.Context, target *types.Target, cache *types.FetchCache) ([]types.Chapter, fetchResponse, error) {
+	settings := getTargetHttpConfig(target)
+	client, err := getHttpClient(settings)
+	if err != nil {
+		return nil, fetchResponse{}, &PermanentError{Err: err}
 	}
 
-	return chapters, nil
+	response, err := fetchBody(ctx, client, settings.UserAgent, target.Source, target.RequestHeaders, cache)
+	if err != nil {
+		return nil, response, err
+	}
+	if response.NotModified {
+		return nil, response, nil
+	}
+
+	// Retrying won't fix a body that can't be parsed
+	chapters, found, err := parseChapters(target, &response.Body)
+	if err != nil {
+		return nil, response, &PermanentError{Err: err}
+	}
+	response.ChaptersFound = found
+
+	return chapters, response, nil
 }
 
-// This starts a gofer process.
-// It doesn't return anything because it's supposed to be called in a goroutine.
-// It does take a *sync.WaitGroup as a parameter so it can tell the main process that it's done, though.
-func startGofer(waiter *sync.WaitGroup, db database.Database, target types.Target) {
+// This starts a gofer process for a single target.
+// It returns the number of newly saved chapters.
+// If another gofer is already working on the same target, it returns a PreoccupiedError.
+// Otherwise, the outcome is recorded as a fetch run in the database.
+// Cancelling the context stops the gofer before it saves anything.
+func startGofer(ctx context.Context, db database.Database, target types.Target) (inserted int, err error) {
 	var chapters []types.Chapter
-	var err error
+	var response fetchResponse
 
-	fmt.Println(helpers.FormattedNow(), "Gofer started for", target.Name)
+	if !claimTarget(target.Name) {
+		return 0, &PreoccupiedError{}
+	}
+	defer releaseTarget(target.Name)
+
+	// Record how this run went once it's over
+	run := types.FetchRun{
+		Target:    target.Name,
+		StartedAt: time.Now(),
+	}
+	defer func() {
+		// A gofer stopped by shutdown tells nothing about the target's health
+		if ctx.Err() != nil {
+			return
+		}
 
-	// Try fetching the source five times
-	var attempts uint = 5
-	for attempts = 5; attempts > 0; attempts-- {
-		chapters, err = fetchChapters(&target)
+		run.FinishedAt = time.Now()
+		run.HttpStatus = response.StatusCode
+		run.ChaptersFound = response.ChaptersFound
+		run.ChaptersParsed = len(chapters)
+		run.ChaptersInserted = inserted
 		if err != nil {
-			fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed fetching:", err.Error(), "| Remaining attempt(s):", attempts)
-			time.Sleep(5 * time.Second)
-			continue
+			run.Error = err.Error()
 		}
 
-		break
+		if saveErr := db.SaveFetchRun(run); saveErr != nil {
+			fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed saving fetch run:", saveErr.Error())
+			return
+		}
+
+		checkTargetHealth(db, target.Name)
+	}()
+
+	fmt.Println(helpers.FormattedNow(), "Gofer started for", target.Name)
+
+	// Get the validators of the previous response, so the source can tell if nothing has changed
+	cache, err := db.GetFetchCache(target.Name)
+	if err != nil {
+		fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed getting fetch cache:", err.Error())
+		cache = types.FetchCache{}
 	}
-	if attempts == 0 {
+	cache.Target = target.Name
+
+	// Try fetching the source, retrying if it's worth it
+	chapters, response, err = fetchChaptersWithRetry(ctx, &target, &cache)
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println(helpers.FormattedNow(), target.Name+":", "Gofer cancelled")
+			return 0, err
+		}
 		fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed all fetching attempts")
-		waiter.Done()
-		return
+		return 0, err
+	}
+	if response.NotModified {
+		fmt.Println(helpers.FormattedNow(), target.Name+":", "Source not modified. Gofer finished")
+		return 0, nil
 	}
 
 	// Save the chapters to DB
 	var retry = 10
 	var saved = false
 	for retry > 0 {
-		err = db.SaveChapters(&chapters)
+		inserted, err = db.SaveChapters(&chapters)
 		if err == nil {
 			retry = 0
 			saved = true
@@ -138,39 +296,56 @@ func startGofer(waiter *sync.WaitGroup, db database.Database, target types.Targe
 	}
 
 	if saved {
+		// Only remember the validators once the response has been handled successfully,
+		// otherwise a broken response could be skipped forever with 304s
+		response.Cache.Target = target.Name
+		err = db.SetFetchCache(response.Cache)
+		if err != nil {
+			fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed saving fetch cache:", err.Error())
+		}
+
 		fmt.Println(helpers.FormattedNow(), target.Name+":", "Gofer finished")
 	} else {
 		fmt.Println(helpers.FormattedNow(), target.Name+":", "Failed saving chapters:", err.Error())
+		return inserted, err
 	}
 
-	waiter.Done()
+	return inserted, nil
 }
 
 // This is the "mother" gofer process.
-// It runs one gofer for every target.
-func startGofers(db database.Database, targets *[]types.Target) error {
+// It runs one gofer for every target, and returns the total number of newly saved chapters.
+func startGofers(ctx context.Context, db database.Database, targets *[]types.Target) (int, error) {
 	// Set on progress flag; cancel if it's up
-	if currentlyFetchingTargets {
-		return &PreoccupiedError{}
+	if !claimAllTargets() {
+		return 0, &PreoccupiedError{}
 	}
-	currentlyFetchingTargets = true
 
 	// Iterate through targets
 	var waiter sync.WaitGroup
+	var counter sync.Mutex
+	inserted := 0
 	for _, target := range *targets {
 		waiter.Add(1)
 
 		// Send gofer to work in a parallel process
-		go startGofer(&waiter, db, target)
+		go func(target types.Target) {
+			defer waiter.Done()
+
+			count, _ := startGofer(ctx, db, target)
+			counter.Lock()
+			inserted += count
+			counter.Unlock()
+		}(target)
 	}
 
 	waiter.Wait()
 
-	// Give it some time to rest
-	time.Sleep(30 * time.Second)
+	// Give it some time to rest (unless the bot is shutting down)
+	sleepContext(ctx, 30*time.Second)
 
 	// Take down flag and return
-	currentlyFetchingTargets = false
+	releaseAllTargets()
 	fmt.Println(helpers.FormattedNow(), "Fetch process finished")
-	return nil
+	return inserted, nil
 }
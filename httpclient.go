@@ -0,0 +1,199 @@
+// This file builds the HTTP clients used by the gofers,
+// according to the global and per-target [http] settings in the config.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// Used when no timeout is configured, so a hung source can't stall the gofers forever.
+const defaultHttpTimeout = 30 * time.Second
+
+// Clients are reused between fetches so connections and cookies are kept.
+// They're keyed by the settings they were built with.
+var httpClients = make(map[types.HttpConfig]*http.Client)
+var httpClientsLock sync.Mutex
+
+// Merges the global HTTP settings with a target's; the target's non-empty settings win.
+func mergeHttpConfig(global types.HttpConfig, target types.HttpConfig) types.HttpConfig {
+	merged := global
+	if target.Timeout != "" {
+		merged.Timeout = target.Timeout
+	}
+	if target.Proxy != "" {
+		merged.Proxy = target.Proxy
+	}
+	if target.UserAgent != "" {
+		merged.UserAgent = target.UserAgent
+	}
+	if target.CookieFile != "" {
+		merged.CookieFile = target.CookieFile
+	}
+	if target.InsecureSkipVerify {
+		merged.InsecureSkipVerify = true
+	}
+	if target.MinTlsVersion != "" {
+		merged.MinTlsVersion = target.MinTlsVersion
+	}
+
+	return merged
+}
+
+// Gets the HTTP settings to use for a target.
+func getTargetHttpConfig(target *types.Target) types.HttpConfig {
+	return mergeHttpConfig(getConfig().Http, target.Http)
+}
+
+// Gets the HTTP client for the given settings, building it if it doesn't exist yet.
+func getHttpClient(settings types.HttpConfig) (*http.Client, error) {
+	httpClientsLock.Lock()
+	defer httpClientsLock.Unlock()
+
+	if client, ok := httpClients[settings]; ok {
+		return client, nil
+	}
+
+	client, err := buildHttpClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	httpClients[settings] = client
+
+	return client, nil
+}
+
+// Builds an HTTP client from the given settings.
+func buildHttpClient(settings types.HttpConfig) (*http.Client, error) {
+	timeout := defaultHttpTimeout
+	if settings.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(settings.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http timeout %q: %w", settings.Timeout, err)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if settings.Proxy != "" {
+		proxy, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http proxy %q: %w", settings.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if settings.InsecureSkipVerify || settings.MinTlsVersion != "" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+		if settings.MinTlsVersion != "" {
+			version, err := parseTlsVersion(settings.MinTlsVersion)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig.MinVersion = version
+		}
+	}
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+
+	if settings.CookieFile != "" {
+		jar, err := loadCookieFile(settings.CookieFile)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+
+	return client, nil
+}
+
+// Turns a TLS version like "1.2" into its crypto/tls constant.
+func parseTlsVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, errors.New("invalid minimum TLS version: " + version)
+}
+
+// Loads a Netscape-format cookies.txt file (the format browser extensions and curl export) into a cookie jar.
+// Each line is: domain, include subdomains, path, secure, expiry, name, value, separated by tabs.
+func loadCookieFile(file string) (*cookiejar.Jar, error) {
+	handle, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cookie file: %w", err)
+	}
+	defer handle.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(handle)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// curl marks HttpOnly cookies with a prefix on an otherwise commented line
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			continue
+		}
+
+		domain := fields[0]
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{
+			Name:   fields[5],
+			Value:  fields[6],
+			Path:   fields[2],
+			Secure: secure,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: "/"}, []*http.Cookie{cookie})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read cookie file: %w", err)
+	}
+
+	return jar, nil
+}
@@ -0,0 +1,337 @@
+// This file handles the languages of the bot's replies and the timezones dates are shown in.
+// Both are set per guild; a guild that hasn't set them gets the defaults.
+// Every reply is looked up by its key in the messages of the guild's language, and falls back to English.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// The language of guilds that haven't set one, and whose Discord locale isn't supported either.
+const defaultLanguage = "en"
+
+// The timezone of guilds that haven't set one. The announcements always used JST before it could be set.
+const defaultTimezone = "Asia/Tokyo"
+
+// A language the bot can reply in.
+type language struct {
+	Code       string // Also the Discord locale it's picked for
+	Name       string
+	DateLayout string
+	messages   map[string]string
+}
+
+var languages = []*language{
+	{
+		Code:       "en",
+		Name:       "English",
+		DateLayout: "Jan 2, 2006 15:04 MST",
+		messages: map[string]string{
+			"shutting-down":                 "The bot is shutting down.",
+			"no-feed-channel":               "You have to set the feed channel for this server first.",
+			"no-permission-feed-channel":    "You do not have the permission to set the feed channel.",
+			"error-feed-channel-set":        "Something went wrong when setting the feed channel...",
+			"feed-channel-set":              "This channel has been set as the feed channel.",
+			"error-server-flags":            "Something went wrong when checking the server flags...",
+			"announcer-busy":                "The bot is working, so hold on.",
+			"error-feed-channel-get":        "Something went wrong when getting the feed channel...",
+			"error-chapters-get":            "Something went wrong when fetching the chapters...",
+			"announcing":                    "Chapters found. Announcing...",
+			"announcing-interrupted":        "Announcing was interrupted because the bot is shutting down.",
+			"error-announcing":              "Something went wrong when announcing a chapter...",
+			"announcing-finished":           "Announcing finished.",
+			"no-new-chapters":               "There are no new chapters to announce.",
+			"error-server-flag-clear":       "Something went wrong when clearing the server flag...",
+			"no-permission-announcing-flag": "You do not have the permission to clear the announcing flag.",
+			"announcing-flag-cleared":       "The announcing flag has been cleared.",
+			"fetch-in-progress":             "The fetch process is currently in progress.",
+			"fetch-started":                 "Started the fetch process.",
+			"no-permission-follows":         "You do not have the permission to change the followed titles.",
+			"title-not-found":               "That title does not exist.",
+			"error-follow":                  "Something went wrong when trying to follow the title...",
+			"followed":                      "This server now follows [%s].",
+			"not-following":                 "This server is not following that title.",
+			"error-unfollow":                "Something went wrong when trying to unfollow the title...",
+			"unfollowed":                    "This server no longer follows [%s].",
+			"error-following-get":           "Something went wrong when getting the followed titles...",
+			"following-everything":          "This server is not following specific titles, so it gets every title.",
+			"following":                     "This server follows: %s",
+			"no-permission-routes":          "You do not have the permission to change the routes.",
+			"route-option-required":         "Specify either a series or a label.",
+			"route-kind-series":             "series",
+			"route-kind-label":              "label",
+			"error-route-add":               "Something went wrong when adding the route...",
+			"routed":                        "Chapters of %s [%s] will be announced in this channel.",
+			"route-not-found":               "That is not routed to this channel.",
+			"error-route-remove":            "Something went wrong when removing the route...",
+			"unrouted":                      "Chapters of %s [%s] will no longer be announced in this channel.",
+			"error-routes-get":              "Something went wrong when getting the routes...",
+			"no-routes":                     "There are no routes, so everything is announced in the feed channel.",
+			"route":                         "%s [%s] → <#%s>",
+			"admins-only":                   "Only bot admins can manage the targets.",
+			"invalid-target-definition":     "That target definition is invalid: %s",
+			"target-exists":                 "A target with that name already exists.",
+			"error-target-add":              "Something went wrong when adding the target...",
+			"target-added":                  "The target [%s] has been added.",
+			"target-not-found":              "That target does not exist.",
+			"error-target-edit":             "Something went wrong when editing the target...",
+			"target-rename":                 "A target can't be renamed, because its chapters, follows, routes, subscriptions and templates go by its name. Add a new target instead.",
+			"target-updated":                "The target [%s] has been updated.",
+			"error-target-change":           "Something went wrong when changing the target...",
+			"target-enabled":                "The target [%s] has been enabled.",
+			"target-disabled":               "The target [%s] has been disabled.",
+			"error-target-remove":           "Something went wrong when removing the target...",
+			"target-removed":                "The target [%s] has been removed.",
+			"target-still-in-config":        " It stays off even though it is still in the config file; add it again to bring it back.",
+			"targets-reload-failed":         " However, reloading the targets failed: %s",
+			"error-targets-get":             "Something went wrong when getting the targets...",
+			"no-targets":                    "There are no targets.",
+			"target-is-disabled":            " — disabled",
+			"target-updated-at":             " (updated %s)",
+			"error-subscribe":               "Something went wrong when trying to subscribe you...",
+			"subscribed":                    "You are now subscribed to [%s].",
+			"not-subscribed":                "You are not subscribed to that title.",
+			"error-unsubscribe":             "Something went wrong when trying to unsubscribe you...",
+			"unsubscribed":                  "You are no longer subscribed to [%s].",
+			"no-permission-settings":        "You do not have the permission to change the settings of this server.",
+			"invalid-timezone":              "\"%s\" is not a timezone. Use a name like Asia/Tokyo or UTC.",
+			"error-timezone-set":            "Something went wrong when setting the timezone...",
+			"timezone-set":                  "Dates in this server are now shown in %s (it's %s there now).",
+			"invalid-language":              "\"%s\" is not a supported language.",
+			"error-language-set":            "Something went wrong when setting the language...",
+			"language-set":                  "This server's language is now English.",
+			"embed-author":                  "Author",
+			"embed-access":                  "Access",
+			"access-free":                   "Free",
+			"access-paid":                   "Paid",
+			"error-templates-get":           "Something went wrong when getting the templates...",
+			"invalid-template":              "That template doesn't work: %s",
+			"template-empty":                "Without the embed, the template has to write something.",
+			"error-template-set":            "Something went wrong when setting the template...",
+			"template-set":                  "Chapters in this server will now be announced like this:",
+			"template-set-series":           "Chapters of [%s] will now be announced like this:",
+			"template-preview-draft":        "Chapters would be announced like this:",
+			"template-preview-default":      "There's no template, so chapters are announced with the embed, like this:",
+			"template-preview":              "The template is:\n```\n%s\n```\nChapters are announced like this:",
+			"template-not-found":            "No such template is set.",
+			"error-template-remove":         "Something went wrong when clearing the template...",
+			"template-cleared":              "Chapters in this server will be announced with the embed again.",
+			"template-cleared-series":       "Chapters of [%s] will be announced like the rest of this server's chapters again.",
+			"sample-chapter-title":          "Chapter 12: A Sample Chapter",
+			"sample-chapter-description":    "This is what a chapter's description looks like.",
+			"invalid-digest-mode":           "\"%s\" is not a digest mode.",
+			"error-digest-mode-set":         "Something went wrong when setting the digest mode...",
+			"digest-mode-set-off":           "Every chapter will now be announced on its own.",
+			"digest-mode-set-series":        "New chapters will now be announced together, in a message for each series.",
+			"digest-mode-set-daily":         "New chapters will now be announced together once a day.",
+			"digest-series":                 "New chapters of [%s]: %d",
+			"digest-daily":                  "Today's new chapters: %d",
+		},
+	},
+	{
+		Code:       "ja",
+		Name:       "日本語",
+		DateLayout: "2006年1月2日 15:04 MST",
+		messages: map[string]string{
+			"shutting-down":                 "ボットは終了処理中です。",
+			"no-feed-channel":               "先にこのサーバーのフィードチャンネルを設定してください。",
+			"no-permission-feed-channel":    "フィードチャンネルを設定する権限がありません。",
+			"error-feed-channel-set":        "フィードチャンネルの設定中に問題が発生しました…",
+			"feed-channel-set":              "このチャンネルをフィードチャンネルに設定しました。",
+			"error-server-flags":            "サーバーの状態の確認中に問題が発生しました…",
+			"announcer-busy":                "ボットが作業中です。少々お待ちください。",
+			"error-feed-channel-get":        "フィードチャンネルの取得中に問題が発生しました…",
+			"error-chapters-get":            "チャプターの取得中に問題が発生しました…",
+			"announcing":                    "新しいチャプターが見つかりました。告知中…",
+			"announcing-interrupted":        "ボットの終了処理のため、告知が中断されました。",
+			"error-announcing":              "チャプターの告知中に問題が発生しました…",
+			"announcing-finished":           "告知が完了しました。",
+			"no-new-chapters":               "告知する新しいチャプターはありません。",
+			"error-server-flag-clear":       "サーバーのフラグの解除中に問題が発生しました…",
+			"no-permission-announcing-flag": "告知フラグを解除する権限がありません。",
+			"announcing-flag-cleared":       "告知フラグを解除しました。",
+			"fetch-in-progress":             "現在取得処理を実行中です。",
+			"fetch-started":                 "取得処理を開始しました。",
+			"no-permission-follows":         "フォローするタイトルを変更する権限がありません。",
+			"title-not-found":               "そのタイトルは存在しません。",
+			"error-follow":                  "タイトルのフォロー中に問題が発生しました…",
+			"followed":                      "このサーバーは[%s]をフォローしました。",
+			"not-following":                 "このサーバーはそのタイトルをフォローしていません。",
+			"error-unfollow":                "タイトルのフォロー解除中に問題が発生しました…",
+			"unfollowed":                    "このサーバーは[%s]のフォローを解除しました。",
+			"error-following-get":           "フォロー中のタイトルの取得中に問題が発生しました…",
+			"following-everything":          "このサーバーは特定のタイトルをフォローしていないため、すべてのタイトルが告知されます。",
+			"following":                     "このサーバーがフォロー中のタイトル：%s",
+			"no-permission-routes":          "ルートを変更する権限がありません。",
+			"route-option-required":         "シリーズかラベルのどちらか一方を指定してください。",
+			"route-kind-series":             "シリーズ",
+			"route-kind-label":              "ラベル",
+			"error-route-add":               "ルートの追加中に問題が発生しました…",
+			"routed":                        "%s[%s]のチャプターはこのチャンネルで告知されます。",
+			"route-not-found":               "それはこのチャンネルにルーティングされていません。",
+			"error-route-remove":            "ルートの削除中に問題が発生しました…",
+			"unrouted":                      "%s[%s]のチャプターはこのチャンネルで告知されなくなりました。",
+			"error-routes-get":              "ルートの取得中に問題が発生しました…",
+			"no-routes":                     "ルートがないため、すべてフィードチャンネルで告知されます。",
+			"route":                         "%s[%s] → <#%s>",
+			"admins-only":                   "ターゲットを管理できるのはボットの管理者のみです。",
+			"invalid-target-definition":     "ターゲットの定義が正しくありません：%s",
+			"target-exists":                 "その名前のターゲットはすでに存在します。",
+			"error-target-add":              "ターゲットの追加中に問題が発生しました…",
+			"target-added":                  "ターゲット[%s]を追加しました。",
+			"target-not-found":              "そのターゲットは存在しません。",
+			"error-target-edit":             "ターゲットの編集中に問題が発生しました…",
+			"target-rename":                 "チャプター、フォロー、ルート、購読、テンプレートが名前で紐づいているため、ターゲットの名前は変更できません。新しいターゲットを追加してください。",
+			"target-updated":                "ターゲット[%s]を更新しました。",
+			"error-target-change":           "ターゲットの変更中に問題が発生しました…",
+			"target-enabled":                "ターゲット[%s]を有効にしました。",
+			"target-disabled":               "ターゲット[%s]を無効にしました。",
+			"error-target-remove":           "ターゲットの削除中に問題が発生しました…",
+			"target-removed":                "ターゲット[%s]を削除しました。",
+			"target-still-in-config":        "設定ファイルに残っていても戻りません。元に戻すにはもう一度追加してください。",
+			"targets-reload-failed":         "ただし、ターゲットの再読み込みに失敗しました：%s",
+			"error-targets-get":             "ターゲットの取得中に問題が発生しました…",
+			"no-targets":                    "ターゲットはありません。",
+			"target-is-disabled":            "（無効）",
+			"target-updated-at":             "（%s更新）",
+			"error-subscribe":               "購読の登録中に問題が発生しました…",
+			"subscribed":                    "[%s]を購読しました。",
+			"not-subscribed":                "そのタイトルは購読していません。",
+			"error-unsubscribe":             "購読の解除中に問題が発生しました…",
+			"unsubscribed":                  "[%s]の購読を解除しました。",
+			"no-permission-settings":        "このサーバーの設定を変更する権限がありません。",
+			"invalid-timezone":              "「%s」はタイムゾーンではありません。Asia/TokyoやUTCのような名前を指定してください。",
+			"error-timezone-set":            "タイムゾーンの設定中に問題が発生しました…",
+			"timezone-set":                  "このサーバーの日時は%sで表示されます（現地時刻：%s）。",
+			"invalid-language":              "「%s」には対応していません。",
+			"error-language-set":            "言語の設定中に問題が発生しました…",
+			"language-set":                  "このサーバーの言語を日本語に設定しました。",
+			"embed-author":                  "作者",
+			"embed-access":                  "閲覧",
+			"access-free":                   "無料",
+			"access-paid":                   "有料",
+			"error-templates-get":           "テンプレートの取得中に問題が発生しました…",
+			"invalid-template":              "そのテンプレートは使えません：%s",
+			"template-empty":                "埋め込みなしの場合、テンプレートは何かを書き出す必要があります。",
+			"error-template-set":            "テンプレートの設定中に問題が発生しました…",
+			"template-set":                  "このサーバーのチャプターは今後このように告知されます：",
+			"template-set-series":           "[%s]のチャプターは今後このように告知されます：",
+			"template-preview-draft":        "チャプターはこのように告知されます：",
+			"template-preview-default":      "テンプレートがないため、チャプターはこのように埋め込みで告知されます：",
+			"template-preview":              "テンプレート：\n```\n%s\n```\nチャプターはこのように告知されます：",
+			"template-not-found":            "そのテンプレートは設定されていません。",
+			"error-template-remove":         "テンプレートの解除中に問題が発生しました…",
+			"template-cleared":              "このサーバーのチャプターは再び埋め込みで告知されます。",
+			"template-cleared-series":       "[%s]のチャプターは再びこのサーバーの他のチャプターと同じように告知されます。",
+			"sample-chapter-title":          "第12話 サンプルのチャプター",
+			"sample-chapter-description":    "チャプターのあらすじはこのように表示されます。",
+			"invalid-digest-mode":           "「%s」というまとめ方はありません。",
+			"error-digest-mode-set":         "まとめ方の設定中に問題が発生しました…",
+			"digest-mode-set-off":           "今後はチャプターを一つずつ告知します。",
+			"digest-mode-set-series":        "今後は新しいチャプターをシリーズごとにまとめて告知します。",
+			"digest-mode-set-daily":         "今後は新しいチャプターを一日一回まとめて告知します。",
+			"digest-series":                 "[%s]の新しいチャプター：%d件",
+			"digest-daily":                  "本日の新しいチャプター：%d件",
+		},
+	},
+}
+
+// Gets a language by its code, or nil if it's not supported.
+func findLanguage(code string) *language {
+	for _, l := range languages {
+		if strings.EqualFold(l.Code, code) {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// Gets the supported languages as the choices of a command option.
+func getLanguageChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(languages))
+	for _, l := range languages {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: l.Name, Value: l.Code})
+	}
+
+	return choices
+}
+
+// Gets the language a guild's replies should be in:
+// the one it has set, or the one matching its Discord locale, or the default.
+func getLanguage(server *types.Server, locale *discordgo.Locale) *language {
+	if server != nil {
+		if l := findLanguage(server.Language); l != nil {
+			return l
+		}
+	}
+	if locale != nil {
+		// Locales like "en-US" are matched by their language
+		if l := findLanguage(strings.SplitN(string(*locale), "-", 2)[0]); l != nil {
+			return l
+		}
+	}
+
+	return findLanguage(defaultLanguage)
+}
+
+// Gets the guild an interaction happened in, or nil if it's not saved (it hasn't set a feed channel).
+func getInteractionServer(i *discordgo.InteractionCreate) *types.Server {
+	if i.GuildID == "" {
+		return nil
+	}
+
+	server, err := db.GetServer(i.GuildID)
+	if err != nil {
+		return nil
+	}
+	return &server
+}
+
+// Gets the language to reply to an interaction in.
+func getInteractionLanguage(i *discordgo.InteractionCreate) *language {
+	return getLanguage(getInteractionServer(i), i.GuildLocale)
+}
+
+// Gets a message in the language, filled in with the arguments.
+// A message the language doesn't have is taken from the default language.
+func (l *language) text(key string, args ...any) string {
+	message, ok := l.messages[key]
+	if !ok {
+		message, ok = findLanguage(defaultLanguage).messages[key]
+	}
+	if !ok {
+		return key
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Formats a date in the language, in the timezone.
+func (l *language) formatDate(date time.Time, location *time.Location) string {
+	return date.In(location).Format(l.DateLayout)
+}
+
+// Gets the timezone a guild's dates are shown in.
+func getTimezone(server *types.Server) *time.Location {
+	if server != nil && server.Timezone != "" {
+		if location, err := time.LoadLocation(server.Timezone); err == nil {
+			return location
+		}
+	}
+
+	location, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
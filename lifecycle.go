@@ -0,0 +1,119 @@
+// This file keeps track of in-flight gofers and announcers,
+// so the bot can stop them cleanly and wait for them when it shuts down.
+
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long to wait for in-flight work to finish on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// Identifies this process, e.g. as the owner of announcing leases.
+var instanceId = makeInstanceId()
+
+func makeInstanceId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return hostname + ":" + strconv.Itoa(os.Getpid()) + ":" + strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+// Cancelled when the bot starts shutting down.
+var appContext, cancelAppContext = context.WithCancel(context.Background())
+
+// Counts in-flight work. Once closed, no new work can be started.
+type workTracker struct {
+	lock   sync.Mutex
+	closed bool
+	group  sync.WaitGroup
+}
+
+var workers workTracker
+
+// Registers a new piece of work. Returns false if the bot is shutting down.
+func (t *workTracker) start() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.closed {
+		return false
+	}
+	t.group.Add(1)
+	return true
+}
+
+// Marks a piece of work as finished.
+func (t *workTracker) done() {
+	t.group.Done()
+}
+
+// Stops new work from being started, then waits until the in-flight work is finished or the timeout passes.
+// Returns false if it timed out.
+func (t *workTracker) closeAndWait(timeout time.Duration) bool {
+	t.lock.Lock()
+	t.closed = true
+	t.lock.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.group.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Runs the work in the current goroutine, unless the bot is shutting down.
+func runWork(work func(ctx context.Context)) bool {
+	if !workers.start() {
+		return false
+	}
+	defer workers.done()
+
+	work(appContext)
+	return true
+}
+
+// Runs the work in a new goroutine, unless the bot is shutting down.
+func goWork(work func(ctx context.Context)) bool {
+	if !workers.start() {
+		return false
+	}
+
+	go func() {
+		defer workers.done()
+		work(appContext)
+	}()
+	return true
+}
+
+// Waits for the given duration, or until the context is cancelled.
+// Returns false if it was cancelled.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
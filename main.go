@@ -6,54 +6,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
+	_ "time/tzdata" // So target timezones work even where the system has no zoneinfo
 
-	"github.com/BurntSushi/toml"
 	"github.com/bwmarrin/discordgo"
 	"github.com/hermitpopcorn/decatholac-mango/database"
 	"github.com/hermitpopcorn/decatholac-mango/helpers"
-	"github.com/hermitpopcorn/decatholac-mango/types"
-	"github.com/robfig/cron/v3"
 )
 
-type configuration struct {
-	Database         string
-	Token            string
-	Targets          []types.Target
-	WebInterfacePort string
-	CronInterval     string
-}
-
-// Read configuration file
-var config configuration
-
-func init() {
-	configFile := "config.toml"
-
-	if _, err := os.Stat(configFile); err != nil {
-		log.Panicln("Config file not found")
-	}
-
-	_, err := toml.DecodeFile(configFile, &config)
-	if err != nil {
-		log.Panicln(err.Error())
-	}
-}
-
 // Prepare database
 var db database.Database
 
-func init() {
-	databaseFile := config.Database
-	if databaseFile == "" {
-		databaseFile = "database.db"
-	}
-
+func openDatabase() {
 	var err error
-	db, err = database.OpenSQLiteDatabase(databaseFile)
+	db, err = database.OpenSQLiteDatabase(getDatabaseFile())
 	if err != nil {
 		panic(err.Error())
 	}
@@ -62,24 +33,40 @@ func init() {
 // Initialize bot
 var session *discordgo.Session
 
-func init() {
+func openSession() {
 	var err error
-	session, err = discordgo.New("Bot " + config.Token)
+	session, err = discordgo.New("Bot " + getConfig().Token)
 	if err != nil {
 		log.Println(err.Error())
 	}
 }
 
 func main() {
+	// Run a subcommand instead of the bot if one is given
+	if len(os.Args) > 1 {
+		os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
+	}
+
+	loadConfig()
+
+	openDatabase()
+	openSession()
+
+	// Nothing can be announcing yet, so any announcing lease left is from a process that died mid-announcement
+	cleared, err := db.ClearAllAnnouncingLeases()
+	if err != nil {
+		log.Println(err.Error())
+	} else if cleared > 0 {
+		fmt.Println(helpers.FormattedNow(), "Recovered", cleared, "stuck announcing flag(s)")
+	}
+
 	fmt.Println(helpers.FormattedNow(), "Press Ctrl+C to exit")
 
 	// Open session
-	err := session.Open()
+	err = session.Open()
 	if err != nil {
 		log.Println(err.Error())
 		session = nil
-	} else {
-		defer session.Close()
 	}
 
 	// Setup Discord commands
@@ -87,38 +74,62 @@ func main() {
 		registerCommands()
 	}
 
-	// Setup cron
-	job := func() {
-		fmt.Println(helpers.FormattedNow(), "Fetch process triggered by cronjob")
-		startGofers(db, &config.Targets)
-
-		if session != nil {
-			fmt.Println(helpers.FormattedNow(), "Global announcement process triggered by cronjob")
-			startAnnouncers(db)
-		} else {
-			fmt.Println(helpers.FormattedNow(), "Global announcement process halted: no Discord session")
-		}
+	// Setup the targets and cron
+	err = db.SeedTargets(getConfig().Targets)
+	if err != nil {
+		log.Panicln(err.Error())
 	}
-	cron := cron.New()
-	cron.AddFunc(config.CronInterval, job)
-	cron.Start()
+	err = reloadTargets()
+	if err != nil {
+		log.Panicln(err.Error())
+	}
+	scheduler.start()
 	// Start once immediately on startup
-	go job()
-	fmt.Println(helpers.FormattedNow(), "Running cron", config.CronInterval)
+	goWork(func(ctx context.Context) {
+		fmt.Println(helpers.FormattedNow(), "Fetch process triggered on startup")
+		targets := getTargets()
+		startGofers(ctx, db, &targets)
+		announceIfPossible(ctx, "startup")
+	})
+	fmt.Println(helpers.FormattedNow(), "Running cron", getConfig().CronInterval)
 
 	// Setup web interface
-	go startWebInterface()
+	restartWebInterface()
 
-	// Exit on Ctrl+C
+	// Reload the config whenever it changes
+	goWork(watchConfig)
+
+	// Reload the config on SIGHUP, and exit on Ctrl+C
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+waiting:
+	for {
+		select {
+		case <-reload:
+			fmt.Println(helpers.FormattedNow(), "Received SIGHUP, reloading "+configFile+"...")
+			reloadConfig()
+		case <-stop:
+			break waiting
+		}
+	}
 
 	fmt.Println(helpers.FormattedNow(), "Goodbye...")
 
-	// Remove commands
+	// Stop scheduling new jobs, tell the running gofers and announcers to stop, and wait for them
+	scheduler.stop()
+	cancelAppContext()
+	if !workers.closeAndWait(shutdownTimeout) {
+		fmt.Println(helpers.FormattedNow(), "Some work did not finish in", shutdownTimeout, "and was abandoned")
+	}
+
+	stopWebInterface()
+
+	// Remove commands and close the session
 	if session != nil {
 		unregisterCommands()
+		session.Close()
 	}
 
 	// Close database
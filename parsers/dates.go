@@ -0,0 +1,338 @@
+// This is the date parsing shared by every mode.
+// A target's dateFormat can be a name ("unix", "RFC3339", "japanese", "relative"...), a Go layout or a strftime pattern,
+// and dates without a timezone of their own are read in the target's timezone.
+
+package parsers
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// A date format, compiled for a target.
+type dateParser struct {
+	format   string
+	layout   string // For Go layouts and strftime patterns
+	location *time.Location
+}
+
+// The formats that are known by name.
+var dateFormatNames = []string{"auto", "RFC3339", "unix", "unix-seconds", "unix-milliseconds", "japanese", "relative"}
+
+// The layouts tried when the format is "auto", after RFC3339.
+var autoDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"2006/1/2",
+	"2006.01.02",
+	"2006.1.2",
+	"20060102",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"02 Jan 2006",
+}
+
+// Timestamps from here up are taken as milliseconds. In seconds, it's the year 5138.
+const unixMillisecondsThreshold = 1e11
+
+// Numbers below this aren't taken as timestamps by "auto", so "20230401" is a date and not a day in 1970.
+const unixAutoThreshold = 1e8
+
+// Gets a target's timezone, an IANA name like "Asia/Tokyo". If it's empty, it's UTC.
+func loadTimezone(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.UTC, nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.New("unknown timezone \"" + timezone + "\" (e.g. Asia/Tokyo or UTC)")
+	}
+	return location, nil
+}
+
+// Compiles a date format. An empty format is the same as "auto", which tries everything.
+// Dates without a timezone of their own are read in the given location.
+func compileDateFormat(format string, location *time.Location) (*dateParser, error) {
+	parser := &dateParser{format: format, location: location}
+	if parser.format == "" {
+		parser.format = "auto"
+	}
+
+	switch {
+	case contains(dateFormatNames, parser.format):
+	case strings.Contains(parser.format, "%"):
+		layout, err := strftimeToLayout(parser.format)
+		if err != nil {
+			return nil, err
+		}
+		parser.layout = layout
+	case isDateLayout(parser.format):
+		parser.layout = parser.format
+	default:
+		return nil, errors.New("must be one of " + strings.Join(dateFormatNames, ", ") +
+			", a Go date layout (e.g. \"2006-01-02\") or a strftime pattern (e.g. \"%Y-%m-%d\"), not \"" + format + "\"")
+	}
+
+	return parser, nil
+}
+
+// Compiles the date format of a target, in its timezone. Field is the format's key, for the error messages.
+func getDateParser(target *types.Target, format string, field string) (*dateParser, error) {
+	location, err := loadTimezone(target.Timezone)
+	if err != nil {
+		return nil, errors.New("timezone: " + err.Error())
+	}
+
+	parser, err := compileDateFormat(format, location)
+	if err != nil {
+		return nil, errors.New(field + ": " + err.Error())
+	}
+	return parser, nil
+}
+
+// Parses a date found in a source, either a string or a number (from JSON).
+// Relative dates like "3 days ago" are counted from now.
+func (p *dateParser) parse(value any, now time.Time) (time.Time, error) {
+	var text string
+	switch value := value.(type) {
+	case string:
+		text = strings.TrimSpace(value)
+	case float64:
+		text = strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return time.Time{}, errors.New("no date found")
+	default:
+		return time.Time{}, errors.New("the date is not a string or a number")
+	}
+	if text == "" {
+		return time.Time{}, errors.New("no date found")
+	}
+
+	switch p.format {
+	case "auto":
+		return p.parseAuto(text, now)
+	case "RFC3339":
+		return time.Parse(time.RFC3339, text)
+	case "unix":
+		return parseUnix(text, 0)
+	case "unix-seconds":
+		return parseUnix(text, time.Second)
+	case "unix-milliseconds":
+		return parseUnix(text, time.Millisecond)
+	case "japanese":
+		return parseJapaneseDate(text, now.In(p.location))
+	case "relative":
+		return parseRelativeDate(text, now.In(p.location))
+	}
+
+	return time.ParseInLocation(p.layout, text, p.location)
+}
+
+// Tries every known format until one fits.
+func (p *dateParser) parseAuto(text string, now time.Time) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, text); err == nil {
+		return date, nil
+	}
+	if timestamp, err := strconv.ParseFloat(text, 64); err == nil && math.Abs(timestamp) >= unixAutoThreshold {
+		return parseUnix(text, 0)
+	}
+	for _, layout := range autoDateLayouts {
+		if date, err := time.ParseInLocation(layout, text, p.location); err == nil {
+			return date, nil
+		}
+	}
+	if date, err := parseJapaneseDate(text, now.In(p.location)); err == nil {
+		return date, nil
+	}
+	if date, err := parseRelativeDate(text, now.In(p.location)); err == nil {
+		return date, nil
+	}
+
+	return time.Time{}, errors.New("unable to recognize the date \"" + text + "\"")
+}
+
+// Parses a unix timestamp. If the unit is 0, it's guessed from the size of the number.
+func parseUnix(text string, unit time.Duration) (time.Time, error) {
+	timestamp, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(timestamp, 0) || math.IsNaN(timestamp) {
+		return time.Time{}, errors.New("unable to parse timestamp \"" + text + "\"")
+	}
+
+	if unit == 0 {
+		unit = time.Second
+		if math.Abs(timestamp) >= unixMillisecondsThreshold {
+			unit = time.Millisecond
+		}
+	}
+
+	if unit == time.Millisecond {
+		milliseconds := int64(timestamp)
+		return time.Unix(milliseconds/1000, (milliseconds%1000)*int64(time.Millisecond)), nil
+	}
+	seconds, fraction := math.Modf(timestamp)
+	return time.Unix(int64(seconds), int64(fraction*float64(time.Second))), nil
+}
+
+// The strftime directives and the Go layouts they become.
+var strftimeDirectives = map[string]string{
+	"Y": "2006", "y": "06", "m": "01", "-m": "1", "d": "02", "-d": "2", "e": "_2",
+	"H": "15", "I": "03", "-I": "3", "M": "04", "S": "05", "p": "PM",
+	"b": "Jan", "h": "Jan", "B": "January", "a": "Mon", "A": "Monday",
+	"z": "-0700", "Z": "MST", "F": "2006-01-02", "T": "15:04:05", "R": "15:04", "D": "01/02/06",
+	"%": "%",
+}
+
+// Turns a strftime pattern like "%Y/%m/%d %H:%M" into a Go layout.
+func strftimeToLayout(format string) (string, error) {
+	var layout strings.Builder
+	for index := 0; index < len(format); index++ {
+		if format[index] != '%' {
+			layout.WriteByte(format[index])
+			continue
+		}
+
+		directive := ""
+		if index+1 < len(format) {
+			directive = format[index+1 : index+2]
+			if directive == "-" && index+2 < len(format) {
+				directive = format[index+1 : index+3]
+			}
+		}
+		replacement, ok := strftimeDirectives[directive]
+		if !ok {
+			return "", errors.New("unsupported strftime directive %" + directive + " in \"" + format + "\"")
+		}
+		layout.WriteString(replacement)
+		index += len(directive)
+	}
+
+	return layout.String(), nil
+}
+
+// Matches Japanese dates like "2023年4月1日", "2023年4月1日(土) 12:00" or "4月1日 12時30分".
+var japaneseDatePattern = regexp.MustCompile(`^(?:(\d{4})年\s*)?(\d{1,2})月\s*(\d{1,2})日(?:\s*[(（][^)）]*[)）])?(?:\s*(\d{1,2})(?::|時)\s*(\d{1,2})?分?)?\s*(?:更新|公開|配信)?$`)
+
+// Parses a Japanese date. Full-width digits are fine.
+// A date without a year is taken as the latest one that's not in the future.
+func parseJapaneseDate(text string, now time.Time) (time.Time, error) {
+	parts := japaneseDatePattern.FindStringSubmatch(toHalfWidthDigits(text))
+	if parts == nil {
+		return time.Time{}, errors.New("not a Japanese date: \"" + text + "\"")
+	}
+
+	number := func(part string) int {
+		value, _ := strconv.Atoi(part)
+		return value
+	}
+	year := now.Year()
+	if parts[1] != "" {
+		year = number(parts[1])
+	}
+	month, day, hour, minute := number(parts[2]), number(parts[3]), number(parts[4]), number(parts[5])
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 24 || minute > 59 {
+		return time.Time{}, errors.New("not a Japanese date: \"" + text + "\"")
+	}
+
+	date := time.Date(year, time.Month(month), day, hour, minute, 0, 0, now.Location())
+	if parts[1] == "" && date.After(now.AddDate(0, 0, 1)) {
+		date = date.AddDate(-1, 0, 0)
+	}
+
+	return date, nil
+}
+
+// Turns full-width digits and colons into ASCII ones.
+func toHalfWidthDigits(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '０' && r <= '９':
+			return r - '０' + '0'
+		case r == '：':
+			return ':'
+		}
+		return r
+	}, text)
+}
+
+// The units of relative dates, in English and Japanese.
+var relativeDateUnits = map[string]time.Duration{
+	"second": time.Second, "sec": time.Second, "秒": time.Second,
+	"minute": time.Minute, "min": time.Minute, "分": time.Minute,
+	"hour": time.Hour, "hr": time.Hour, "時間": time.Hour,
+	"day": 24 * time.Hour, "日": 24 * time.Hour,
+	"week": 7 * 24 * time.Hour, "週間": 7 * 24 * time.Hour,
+	"month": 0, "ヶ月": 0, "か月": 0, "カ月": 0, "ヵ月": 0, "ケ月": 0,
+	"year": -1, "年": -1,
+}
+
+// The days that have a word of their own, counted back from today.
+var relativeDateWords = map[string]int{
+	"today": 0, "今日": 0, "本日": 0,
+	"yesterday": 1, "昨日": 1,
+	"一昨日": 2, "おととい": 2,
+}
+
+var englishRelativeDatePattern = regexp.MustCompile(`^(\d+|an?|one)\s+([a-z]+?)s?\s+ago$`)
+var japaneseRelativeDatePattern = regexp.MustCompile(`^(\d+)\s*(秒|分|時間|日|週間|ヶ月|か月|カ月|ヵ月|ケ月|年)前$`)
+
+// Parses a relative date like "3 days ago", "yesterday", "2時間前" or "昨日".
+// Dates a day or more ago are taken as the start of that day, so they don't change between fetches.
+func parseRelativeDate(text string, now time.Time) (time.Time, error) {
+	text = strings.ToLower(toHalfWidthDigits(strings.TrimSpace(text)))
+	startOfDay := func(date time.Time) time.Time {
+		return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	}
+
+	if text == "just now" || text == "now" || text == "たった今" || text == "今" {
+		return now, nil
+	}
+	if days, ok := relativeDateWords[text]; ok {
+		return startOfDay(now.AddDate(0, 0, -days)), nil
+	}
+
+	var count int
+	var unitName string
+	if parts := englishRelativeDatePattern.FindStringSubmatch(text); parts != nil {
+		count, unitName = 1, parts[2]
+		if value, err := strconv.Atoi(parts[1]); err == nil {
+			count = value
+		}
+	} else if parts := japaneseRelativeDatePattern.FindStringSubmatch(text); parts != nil {
+		count, _ = strconv.Atoi(parts[1])
+		unitName = parts[2]
+	} else {
+		return time.Time{}, errors.New("not a relative date: \"" + text + "\"")
+	}
+
+	unit, ok := relativeDateUnits[unitName]
+	if !ok {
+		return time.Time{}, errors.New("unknown unit in relative date: \"" + text + "\"")
+	}
+	switch unit {
+	case 0:
+		return startOfDay(now.AddDate(0, -count, 0)), nil
+	case -1:
+		return startOfDay(now.AddDate(-count, 0, 0)), nil
+	}
+
+	if unit >= 24*time.Hour {
+		return startOfDay(now.AddDate(0, 0, -count*int(unit/(24*time.Hour)))), nil
+	}
+	return now.Add(-time.Duration(count) * unit), nil
+}
@@ -0,0 +1,153 @@
+package parsers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+func TestDateParser(t *testing.T) {
+	tokyo, err := loadTimezone("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	now := time.Date(2023, time.April, 10, 15, 30, 0, 0, tokyo)
+	jst := func(year int, month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, tokyo)
+	}
+
+	for _, test := range []struct {
+		format   string
+		value    any
+		expected time.Time
+	}{
+		// Timestamps, guessing seconds and milliseconds
+		{"unix", float64(1680307200), jst(2023, time.April, 1, 9, 0)},
+		{"unix", float64(1680307200000), jst(2023, time.April, 1, 9, 0)},
+		{"unix", "1680307200", jst(2023, time.April, 1, 9, 0)},
+		{"unix-seconds", "1680307200", jst(2023, time.April, 1, 9, 0)},
+		{"unix-milliseconds", float64(1680307200000), jst(2023, time.April, 1, 9, 0)},
+		{"RFC3339", "2023-04-01T09:00:00+09:00", jst(2023, time.April, 1, 9, 0)},
+
+		// Layouts are read in the timezone, unless the date has one of its own
+		{"2006/01/02 15:04", "2023/04/01 12:00", jst(2023, time.April, 1, 12, 0)},
+		{"Jan 2, 2006 15:04 MST", "Apr 1, 2023 03:00 UTC", jst(2023, time.April, 1, 12, 0)},
+		{"%Y年%-m月%-d日 %H:%M", "2023年4月1日 12:00", jst(2023, time.April, 1, 12, 0)},
+		{"%d %b %Y", "01 Apr 2023", jst(2023, time.April, 1, 0, 0)},
+
+		// Japanese dates
+		{"japanese", "2023年4月1日", jst(2023, time.April, 1, 0, 0)},
+		{"japanese", "２０２３年４月１日（土） １２：３０", jst(2023, time.April, 1, 12, 30)},
+		{"japanese", "12月24日 18時 更新", jst(2022, time.December, 24, 18, 0)},
+		{"japanese", "4月1日", jst(2023, time.April, 1, 0, 0)},
+
+		// Relative dates
+		{"relative", "3 days ago", jst(2023, time.April, 7, 0, 0)},
+		{"relative", "an hour ago", jst(2023, time.April, 10, 14, 30)},
+		{"relative", "Yesterday", jst(2023, time.April, 9, 0, 0)},
+		{"relative", "2 weeks ago", jst(2023, time.March, 27, 0, 0)},
+		{"relative", "1 month ago", jst(2023, time.March, 10, 0, 0)},
+		{"relative", "昨日", jst(2023, time.April, 9, 0, 0)},
+		{"relative", "5時間前", jst(2023, time.April, 10, 10, 30)},
+		{"relative", "2日前", jst(2023, time.April, 8, 0, 0)},
+		{"relative", "たった今", now},
+
+		// Auto tries everything
+		{"", "2023-04-01T09:00:00+09:00", jst(2023, time.April, 1, 9, 0)},
+		{"", float64(1680307200000), jst(2023, time.April, 1, 9, 0)},
+		{"", "20230401", jst(2023, time.April, 1, 0, 0)},
+		{"", "April 1, 2023", jst(2023, time.April, 1, 0, 0)},
+		{"", "2023年4月1日", jst(2023, time.April, 1, 0, 0)},
+		{"auto", "3日前", jst(2023, time.April, 7, 0, 0)},
+	} {
+		parser, err := compileDateFormat(test.format, tokyo)
+		if err != nil {
+			t.Error(test.format, err.Error())
+			continue
+		}
+
+		date, err := parser.parse(test.value, now)
+		if err != nil {
+			t.Error(test.format, test.value, err.Error())
+		} else if !date.Equal(test.expected) {
+			t.Error(test.format, test.value, "expected", test.expected, "found", date)
+		}
+	}
+}
+
+func TestDateParserErrors(t *testing.T) {
+	for _, format := range []string{"yyyy-mm-dd", "%Y-%Q", "unix-minutes"} {
+		if _, err := compileDateFormat(format, time.UTC); err == nil {
+			t.Error("Expected an error for the format", format)
+		}
+	}
+	if _, err := loadTimezone("Asia/Tokio"); err == nil {
+		t.Error("Expected an error for the timezone")
+	}
+
+	now := time.Now()
+	for _, test := range []struct {
+		format string
+		value  any
+	}{
+		{"unix", "yesterday"},
+		{"RFC3339", float64(1680307200)},
+		{"japanese", "13月1日"},
+		{"relative", "3 fortnights ago"},
+		{"", "not a date"},
+		{"", nil},
+		{"", true},
+	} {
+		parser, err := compileDateFormat(test.format, time.UTC)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := parser.parse(test.value, now); err == nil {
+			t.Error("Expected an error for", test.format, test.value)
+		}
+	}
+}
+
+func TestHtmlParserWithTimezone(t *testing.T) {
+	testHtml := `<ul>
+		<li><a href="/2">第2話</a><span class="date">2023年4月8日</span></li>
+		<li><a href="/1">第1話</a><span class="date">2023年4月1日</span></li>
+	</ul>`
+	testTarget := types.Target{
+		Name:     "HTML Test Manga",
+		Mode:     "html",
+		BaseUrl:  "https://comic.com",
+		Timezone: "Asia/Tokyo",
+		Tags: types.Tags{
+			ChaptersTag:  "li",
+			NumberTag:    "a",
+			TitleTag:     "a",
+			UrlTag:       "a",
+			UrlAttribute: "href",
+			DateTag:      ".date",
+			DateFormat:   "japanese",
+		},
+	}
+
+	parsed, err := ParseHtml(&testTarget, &testHtml)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(parsed) != 2 {
+		t.Fatal("Size mismatch: expected 2, found", len(parsed))
+	}
+
+	// Midnight in Tokyo is still the previous day in UTC
+	expected := time.Date(2023, time.March, 31, 15, 0, 0, 0, time.UTC)
+	if !parsed[0].Date.Equal(expected) || len(parsed[0].Defaulted) > 0 {
+		t.Error("Expected", expected, "found", parsed[0].Date, parsed[0].Defaulted)
+	}
+
+	// A timezone that doesn't exist fails the parse
+	testTarget.Timezone = "Asia/Tokio"
+	_, err = ParseHtml(&testTarget, &testHtml)
+	if err == nil {
+		t.Error("Expected an error for the unknown timezone")
+	}
+}
@@ -0,0 +1,356 @@
+// This is the filtering shared by every mode: after a source is parsed,
+// the chapters are kept or dropped according to the target's filters (e.g. to drop previews, ads and announcements).
+
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// A filter with its regular expression, durations and dates parsed.
+type compiledFilter struct {
+	field     string
+	match     *regexp.Regexp
+	operator  string
+	value     string
+	number    float64 // Value as a number, if it is one
+	isNumber  bool
+	date      time.Time // Value as a date, for the "date" field
+	olderThan time.Duration
+	newerThan time.Duration
+	before    time.Time
+	after     time.Time
+	all       []*compiledFilter
+	any       []*compiledFilter
+	not       *compiledFilter
+}
+
+var filterFields = []string{"title", "number", "url", "date", "author", "description", "access"}
+var filterOperators = []string{"==", "!=", "<", "<=", ">", ">="}
+
+// Matches the first number in a text, e.g. 105 in "Chapter 105" or 1.5 in "第1.5話".
+var numberPattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// Compiles a filter, checking that everything in it makes sense.
+func compileFilter(filter *types.Filter) (*compiledFilter, error) {
+	compiled := &compiledFilter{
+		field:    strings.ToLower(filter.Field),
+		operator: filter.Operator,
+		value:    filter.Value,
+	}
+
+	hasCondition := false
+	needsField := filter.Match != "" || filter.Operator != ""
+	needsDate := filter.OlderThan != "" || filter.NewerThan != "" || filter.Before != "" || filter.After != ""
+
+	if compiled.field != "" && !contains(filterFields, compiled.field) {
+		return nil, errors.New("field must be one of " + strings.Join(filterFields, ", ") + ", not \"" + filter.Field + "\"")
+	}
+	if needsField && compiled.field == "" {
+		return nil, errors.New("field is required with match and operator")
+	}
+	if needsDate {
+		if compiled.field != "" && compiled.field != "date" {
+			return nil, errors.New("olderThan, newerThan, before and after only work on the date field")
+		}
+		hasCondition = true
+	}
+
+	if filter.Match != "" {
+		var err error
+		compiled.match, err = regexp.Compile(filter.Match)
+		if err != nil {
+			return nil, errors.New("invalid regular expression in match: " + err.Error())
+		}
+		hasCondition = true
+	}
+
+	if filter.Operator != "" {
+		if !contains(filterOperators, filter.Operator) {
+			return nil, errors.New("operator must be one of " + strings.Join(filterOperators, " ") + ", not \"" + filter.Operator + "\"")
+		}
+		if compiled.field == "date" {
+			var err error
+			compiled.date, err = parseFilterDate(filter.Value)
+			if err != nil {
+				return nil, err
+			}
+		} else if number, err := strconv.ParseFloat(filter.Value, 64); err == nil {
+			compiled.number = number
+			compiled.isNumber = true
+		}
+		hasCondition = true
+	} else if filter.Value != "" {
+		return nil, errors.New("value is set, but there's no operator to compare it with")
+	}
+
+	var err error
+	if compiled.olderThan, err = parseFilterDuration(filter.OlderThan); err != nil {
+		return nil, err
+	}
+	if compiled.newerThan, err = parseFilterDuration(filter.NewerThan); err != nil {
+		return nil, err
+	}
+	if filter.Before != "" {
+		if compiled.before, err = parseFilterDate(filter.Before); err != nil {
+			return nil, err
+		}
+	}
+	if filter.After != "" {
+		if compiled.after, err = parseFilterDate(filter.After); err != nil {
+			return nil, err
+		}
+	}
+
+	for index := range filter.All {
+		child, err := compileFilter(&filter.All[index])
+		if err != nil {
+			return nil, fmt.Errorf("all[%d]: %w", index, err)
+		}
+		compiled.all = append(compiled.all, child)
+		hasCondition = true
+	}
+	for index := range filter.Any {
+		child, err := compileFilter(&filter.Any[index])
+		if err != nil {
+			return nil, fmt.Errorf("any[%d]: %w", index, err)
+		}
+		compiled.any = append(compiled.any, child)
+		hasCondition = true
+	}
+	if filter.Not != nil {
+		compiled.not, err = compileFilter(filter.Not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		hasCondition = true
+	}
+
+	if !hasCondition {
+		return nil, errors.New("the filter has no condition")
+	}
+
+	return compiled, nil
+}
+
+// Parses a duration for olderThan and newerThan. Days ("30d") and weeks ("2w") are allowed too.
+func parseFilterDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			count, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+			if err == nil && count >= 0 {
+				return time.Duration(count * float64(unit)), nil
+			}
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, errors.New("invalid duration \"" + value + "\" (e.g. 30d, 2w or 12h)")
+	}
+	return duration, nil
+}
+
+// Parses a date for before, after and comparisons on the date field.
+func parseFilterDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, errors.New("invalid date \"" + value + "\" (e.g. 2023-04-01 or 2023-04-01T00:00:00+09:00)")
+}
+
+// Checks whether a chapter matches the filter.
+func (f *compiledFilter) matches(chapter *types.Chapter, now time.Time) bool {
+	text := getFilterField(chapter, f.field)
+
+	if f.match != nil && !f.match.MatchString(text) {
+		return false
+	}
+
+	if f.operator != "" && !f.compare(chapter, text) {
+		return false
+	}
+
+	if f.olderThan > 0 && !chapter.Date.Before(now.Add(-f.olderThan)) {
+		return false
+	}
+	if f.newerThan > 0 && !chapter.Date.After(now.Add(-f.newerThan)) {
+		return false
+	}
+	if !f.before.IsZero() && !chapter.Date.Before(f.before) {
+		return false
+	}
+	if !f.after.IsZero() && !chapter.Date.After(f.after) {
+		return false
+	}
+
+	for _, child := range f.all {
+		if !child.matches(chapter, now) {
+			return false
+		}
+	}
+	if len(f.any) > 0 {
+		matched := false
+		for _, child := range f.any {
+			if child.matches(chapter, now) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.not != nil && f.not.matches(chapter, now) {
+		return false
+	}
+
+	return true
+}
+
+// Compares the field with the filter's value.
+func (f *compiledFilter) compare(chapter *types.Chapter, text string) bool {
+	if f.field == "date" {
+		switch {
+		case chapter.Date.Before(f.date):
+			return compareWith(-1, f.operator)
+		case chapter.Date.After(f.date):
+			return compareWith(1, f.operator)
+		default:
+			return compareWith(0, f.operator)
+		}
+	}
+
+	if f.isNumber {
+		if found := numberPattern.FindString(text); found != "" {
+			number, _ := strconv.ParseFloat(found, 64)
+			switch {
+			case number < f.number:
+				return compareWith(-1, f.operator)
+			case number > f.number:
+				return compareWith(1, f.operator)
+			default:
+				return compareWith(0, f.operator)
+			}
+		}
+		return f.operator == "!="
+	}
+
+	return compareWith(strings.Compare(text, f.value), f.operator)
+}
+
+// Checks the result of a comparison (-1, 0 or 1) against an operator.
+func compareWith(result int, operator string) bool {
+	switch operator {
+	case "==":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+
+	return false
+}
+
+// Gets the text of a chapter's field.
+func getFilterField(chapter *types.Chapter, field string) string {
+	switch field {
+	case "title":
+		return chapter.Title
+	case "number":
+		return chapter.Number
+	case "url":
+		return chapter.Url
+	case "date":
+		return chapter.Date.Format(time.RFC3339)
+	case "author":
+		return chapter.Author
+	case "description":
+		return chapter.Description
+	case "access":
+		return chapter.Access
+	}
+
+	return ""
+}
+
+// Compiles every filter of a target.
+func compileFilters(filters *types.Filters) (include []*compiledFilter, exclude []*compiledFilter, err error) {
+	for index := range filters.Include {
+		compiled, err := compileFilter(&filters.Include[index])
+		if err != nil {
+			return nil, nil, fmt.Errorf("filters.include[%d]: %w", index, err)
+		}
+		include = append(include, compiled)
+	}
+	for index := range filters.Exclude {
+		compiled, err := compileFilter(&filters.Exclude[index])
+		if err != nil {
+			return nil, nil, fmt.Errorf("filters.exclude[%d]: %w", index, err)
+		}
+		exclude = append(exclude, compiled)
+	}
+
+	return include, exclude, nil
+}
+
+// Drops the chapters the target's filters don't let through.
+func FilterChapters(target *types.Target, chapters []types.Chapter) ([]types.Chapter, error) {
+	return filterChaptersAt(&target.Filters, chapters, time.Now())
+}
+
+func filterChaptersAt(filters *types.Filters, chapters []types.Chapter, now time.Time) ([]types.Chapter, error) {
+	include, exclude, err := compileFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(include) < 1 && len(exclude) < 1 {
+		return chapters, nil
+	}
+
+	kept := make([]types.Chapter, 0, len(chapters))
+	for index := range chapters {
+		chapter := &chapters[index]
+		if passesFilters(chapter, include, exclude, now) {
+			kept = append(kept, *chapter)
+		}
+	}
+
+	return kept, nil
+}
+
+func passesFilters(chapter *types.Chapter, include []*compiledFilter, exclude []*compiledFilter, now time.Time) bool {
+	for _, filter := range include {
+		if !filter.matches(chapter, now) {
+			return false
+		}
+	}
+	for _, filter := range exclude {
+		if filter.matches(chapter, now) {
+			return false
+		}
+	}
+
+	return true
+}
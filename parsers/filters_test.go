@@ -0,0 +1,192 @@
+package parsers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// Filters the chapters and checks that exactly the expected numbers are left, in order.
+func expectFiltered(t *testing.T, filters types.Filters, chapters []types.Chapter, now time.Time, numbers ...string) {
+	t.Helper()
+
+	filtered, err := filterChaptersAt(&filters, chapters, now)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(filtered) != len(numbers) {
+		t.Fatal("Expected", numbers, "but found", filtered)
+	}
+	for index, number := range numbers {
+		if filtered[index].Number != number {
+			t.Error("Expected", numbers, "but found", filtered)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	now := time.Date(2023, time.April, 1, 12, 0, 0, 0, time.UTC)
+	chapters := []types.Chapter{
+		{Number: "第1話", Title: "Chapter 1", Url: "https://comic.com/1", Date: now.AddDate(0, 0, -60)},
+		{Number: "第2話", Title: "Chapter 2", Url: "https://comic.com/2", Date: now.AddDate(0, 0, -20)},
+		{Number: "予告", Title: "Chapter 3 Preview", Url: "https://comic.com/preview/3", Date: now.AddDate(0, 0, -10)},
+		{Number: "第3話", Title: "Chapter 3", Url: "https://comic.com/3", Date: now.AddDate(0, 0, -3)},
+		{Number: "お知らせ", Title: "休載告知", Url: "https://comic.com/news", Date: now.AddDate(0, 0, -1)},
+	}
+
+	// No filters keeps everything
+	expectFiltered(t, types.Filters{}, chapters, now, "第1話", "第2話", "予告", "第3話", "お知らせ")
+
+	// Excluding by regular expression
+	expectFiltered(t, types.Filters{
+		Exclude: []types.Filter{
+			{Field: "title", Match: "(?i)preview|告知"},
+		},
+	}, chapters, now, "第1話", "第2話", "第3話")
+
+	// Including by regular expression
+	expectFiltered(t, types.Filters{
+		Include: []types.Filter{
+			{Field: "url", Match: `^https://comic\.com/\d+$`},
+		},
+	}, chapters, now, "第1話", "第2話", "第3話")
+
+	// Comparing numbers; chapters without a number only match !=
+	expectFiltered(t, types.Filters{
+		Include: []types.Filter{
+			{Field: "number", Operator: ">=", Value: "2"},
+		},
+	}, chapters, now, "第2話", "第3話")
+	expectFiltered(t, types.Filters{
+		Exclude: []types.Filter{
+			{Field: "number", Operator: "!=", Value: "3"},
+		},
+	}, chapters, now, "第3話")
+
+	// Comparing text
+	expectFiltered(t, types.Filters{
+		Exclude: []types.Filter{
+			{Field: "title", Operator: "==", Value: "Chapter 2"},
+		},
+	}, chapters, now, "第1話", "予告", "第3話", "お知らせ")
+
+	// Date ranges
+	expectFiltered(t, types.Filters{
+		Exclude: []types.Filter{
+			{Field: "date", OlderThan: "30d"},
+		},
+	}, chapters, now, "第2話", "予告", "第3話", "お知らせ")
+	expectFiltered(t, types.Filters{
+		Include: []types.Filter{
+			{NewerThan: "1w"},
+		},
+	}, chapters, now, "第3話", "お知らせ")
+	expectFiltered(t, types.Filters{
+		Include: []types.Filter{
+			{Field: "date", After: "2023-03-01", Before: "2023-03-25T00:00:00Z"},
+		},
+	}, chapters, now, "第2話", "予告")
+	expectFiltered(t, types.Filters{
+		Include: []types.Filter{
+			{Field: "date", Operator: "<", Value: "2023-03-01"},
+		},
+	}, chapters, now, "第1話")
+
+	// Combinations
+	expectFiltered(t, types.Filters{
+		Exclude: []types.Filter{
+			{Any: []types.Filter{
+				{Field: "title", Match: "Preview"},
+				{Field: "url", Match: "/news$"},
+			}},
+		},
+	}, chapters, now, "第1話", "第2話", "第3話")
+	expectFiltered(t, types.Filters{
+		Exclude: []types.Filter{
+			{All: []types.Filter{
+				{Field: "date", OlderThan: "5d"},
+				{Not: &types.Filter{Field: "number", Match: "^第"}},
+			}},
+		},
+	}, chapters, now, "第1話", "第2話", "第3話", "お知らせ")
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, filter := range []types.Filter{
+		{},
+		{Field: "volume", Match: "1"},
+		{Match: "Preview"},
+		{Field: "title", Match: "(unclosed"},
+		{Field: "number", Operator: "=>", Value: "2"},
+		{Field: "number", Value: "2"},
+		{Field: "title", OlderThan: "30d"},
+		{Field: "date", OlderThan: "a month"},
+		{Field: "date", Before: "April 1st"},
+		{Field: "date", Operator: ">", Value: "yesterday"},
+		{Any: []types.Filter{{Field: "title"}}},
+		{Not: &types.Filter{}},
+	} {
+		if _, err := compileFilter(&filter); err == nil {
+			t.Error("Expected an error for", filter)
+		}
+	}
+
+	// The problems are found by ValidateTarget as well
+	target := types.Target{
+		Name:   "RSS Test Publishing",
+		Source: "https://comic-rss.com/rss/11111",
+		Mode:   "rss",
+		Filters: types.Filters{
+			Include: []types.Filter{{Field: "title", Match: "Part"}},
+			Exclude: []types.Filter{{Field: "title", Match: "Preview"}, {Field: "title", Match: "(unclosed"}},
+		},
+	}
+	expectProblems(t, ValidateTarget(&target), "filters.exclude[1]")
+}
+
+func TestRssParserWithFilters(t *testing.T) {
+	testRss := `<?xml version="1.0"?>
+	<rss version="2.0">
+		<channel>
+			<title>RSS Test Publishing</title>
+			<item>
+				<title>Part 25 Preview</title>
+				<link>https://comic-rss.com/episode/preview</link>
+				<pubDate>Fri, 30 Sep 2022 03:00:00 +0000</pubDate>
+			</item>
+			<item>
+				<title>Part 24: The Omega</title>
+				<link>https://comic-rss.com/episode/00024</link>
+				<pubDate>Fri, 23 Sep 2022 03:00:00 +0000</pubDate>
+			</item>
+		</channel>
+	</rss>`
+	testTarget := types.Target{
+		Name: "RSS Test Publishing",
+		Mode: "rss",
+		Filters: types.Filters{
+			Exclude: []types.Filter{{Field: "title", Match: "(?i)preview"}},
+		},
+	}
+
+	parsed, err := ParseRss(&testTarget, &testRss)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(parsed) != 1 {
+		t.Fatal("Size mismatch: expected 1, found", len(parsed))
+	}
+	if parsed[0].Title != "Part 24: The Omega" {
+		t.Error("Expected Part 24 to be kept, found", parsed[0].Title)
+	}
+
+	// A filter that can't be compiled fails the parse instead of letting everything through
+	testTarget.Filters.Exclude[0].Match = "(unclosed"
+	_, err = ParseRss(&testTarget, &testRss)
+	if err == nil {
+		t.Error("Expected an error for the invalid filter")
+	}
+}
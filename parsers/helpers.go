@@ -1,6 +1,10 @@
 package parsers
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
 
 // Make full URL by prepending a base URL if it's a relative URL.
 func makeFullUrl(url string, baseUrl string) string {
@@ -9,3 +13,15 @@ func makeFullUrl(url string, baseUrl string) string {
 	}
 	return url
 }
+
+// Turns a description into plain text: HTML tags are removed and whitespace is collapsed.
+func cleanDescription(description string) string {
+	if strings.Contains(description, "<") {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(description))
+		if err == nil {
+			description = doc.Text()
+		}
+	}
+
+	return strings.Join(strings.Fields(description), " ")
+}
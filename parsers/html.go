@@ -37,6 +37,23 @@ func getNodeText(node *goquery.Selection, tag string, attribute string) string {
 	}
 }
 
+// Tells whether a chapter is free or paid by the elements inside its node.
+// If only one of the tags is set, a chapter that doesn't have it is the other one.
+func getNodeAccess(node *goquery.Selection, freeTag string, paidTag string) string {
+	switch {
+	case paidTag != "" && node.Find(paidTag).Length() > 0:
+		return types.AccessPaid
+	case freeTag != "" && node.Find(freeTag).Length() > 0:
+		return types.AccessFree
+	case paidTag != "" && freeTag == "":
+		return types.AccessFree
+	case freeTag != "" && paidTag == "":
+		return types.AccessPaid
+	}
+
+	return ""
+}
+
 // Parses the given HTML string using the target information and returns an array of Chapters.
 func ParseHtml(target *types.Target, htmlString *string) ([]types.Chapter, error) {
 	reader := strings.NewReader(*htmlString)
@@ -45,6 +62,12 @@ func ParseHtml(target *types.Target, htmlString *string) ([]types.Chapter, error
 		return nil, err
 	}
 
+	dates, err := getDateParser(target, target.Tags.DateFormat, "tags.dateFormat")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
 	var chapters = make([]types.Chapter, 0)
 
 	// Get the chapters' list container nodes
@@ -79,17 +102,36 @@ func ParseHtml(target *types.Target, htmlString *string) ([]types.Chapter, error
 		}
 		chapter.Url = makeFullUrl(url, target.BaseUrl)
 
+		// Get the optional details
+		if target.Tags.ThumbnailTag != "" || target.Tags.ThumbnailAttribute != "" {
+			if thumbnail := getNodeText(node, target.Tags.ThumbnailTag, target.Tags.ThumbnailAttribute); thumbnail != "" {
+				chapter.Thumbnail = makeFullUrl(strings.TrimSpace(thumbnail), target.BaseUrl)
+			}
+		}
+		if target.Tags.DescriptionTag != "" || target.Tags.DescriptionAttribute != "" {
+			chapter.Description = cleanDescription(getNodeText(node, target.Tags.DescriptionTag, target.Tags.DescriptionAttribute))
+		}
+		if target.Tags.AuthorTag != "" || target.Tags.AuthorAttribute != "" {
+			chapter.Author = strings.TrimSpace(getNodeText(node, target.Tags.AuthorTag, target.Tags.AuthorAttribute))
+		}
+		chapter.Access = getNodeAccess(node, target.Tags.FreeTag, target.Tags.PaidTag)
+
 		// Get publish date
-		chapter.Date = time.Now()
+		chapter.Date = now
+		dated := false
 		if target.Tags.DateTag != "" {
 			date := getNodeText(node, target.Tags.DateTag, target.Tags.DateAttribute)
 			if len(date) > 0 {
-				parsedDate, parseErr := time.Parse(target.Tags.DateFormat, date)
+				parsedDate, parseErr := dates.parse(date, now)
 				if parseErr == nil {
 					chapter.Date = parsedDate
+					dated = true
 				}
 			}
 		}
+		if !dated {
+			chapter.Defaulted = append(chapter.Defaulted, "Date")
+		}
 
 		chapters = append(chapters, chapter)
 	})
@@ -101,5 +143,5 @@ func ParseHtml(target *types.Target, htmlString *string) ([]types.Chapter, error
 		}
 	}
 
-	return chapters, nil
+	return FilterChapters(target, chapters)
 }
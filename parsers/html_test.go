@@ -103,3 +103,56 @@ func TestHtmlParser(t *testing.T) {
 		t.Error("Different second element", parsed[1], secondChapter)
 	}
 }
+
+func TestHtmlParserDetails(t *testing.T) {
+	// Prepare a pre-set HTML where the paid chapters have a coin icon
+	testHtml := `
+		<ul>
+			<li data-thumb="/thumbs/2.jpg">
+				<a href="/chapter/2">Chapter 2</a>
+				<p class="summary">The <em>second</em> one.</p>
+				<span class="author">Noowee</span>
+				<i class="icon-coin"></i>
+			</li>
+			<li data-thumb="/thumbs/1.jpg">
+				<a href="/chapter/1">Chapter 1</a>
+			</li>
+		</ul>
+	`
+	testTarget := types.Target{
+		Name:    "HTML Test Manga",
+		Mode:    "html",
+		BaseUrl: "https://test.com",
+		Tags: types.Tags{
+			ChaptersTag:        "li",
+			NumberTag:          "a",
+			TitleTag:           "a",
+			UrlTag:             "a",
+			UrlAttribute:       "href",
+			ThumbnailAttribute: "data-thumb",
+			DescriptionTag:     "p.summary",
+			AuthorTag:          ".author",
+			PaidTag:            ".icon-coin",
+		},
+	}
+
+	parsed, err := ParseHtml(&testTarget, &testHtml)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(parsed) != 2 {
+		t.Fatal("Size mismatch: expected 2, found", len(parsed))
+	}
+
+	for index, expected := range []types.Chapter{
+		{Thumbnail: "https://test.com/thumbs/1.jpg", Access: types.AccessFree},
+		{Thumbnail: "https://test.com/thumbs/2.jpg", Description: "The second one.", Author: "Noowee", Access: types.AccessPaid},
+	} {
+		if parsed[index].Thumbnail != expected.Thumbnail ||
+			parsed[index].Description != expected.Description ||
+			parsed[index].Author != expected.Author ||
+			parsed[index].Access != expected.Access {
+			t.Error("Different details of element", index, parsed[index], expected)
+		}
+	}
+}
@@ -5,104 +5,319 @@ package parsers
 import (
 	"encoding/json"
 	"errors"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hermitpopcorn/decatholac-mango/types"
 )
 
-// Traverses to one or more values and then concatenates them.
-func parseComponent(data map[string]any, key string) string {
-	keys := strings.Split(key, "+")
+// The compiled paths of a target's keys.
+type jsonPaths struct {
+	chapters *Path
+	number   *jsonField
+	title    *jsonField
+	url      *jsonField
+	date     *Path
+	skip     []jsonSkip
 
-	var components []string
-	for _, k := range keys {
-		component := traverse(data, k).(string)
-		if component != "" {
-			components = append(components, component)
+	// Optional; nil if not set
+	thumbnail   *jsonField
+	description *jsonField
+	author      *jsonField
+	free        *Path
+	paid        *Path
+}
+
+type jsonSkip struct {
+	path  *Path
+	value any
+}
+
+// Compiles the paths of a target's keys.
+func compileJsonPaths(keys *types.Keys) (*jsonPaths, error) {
+	var paths jsonPaths
+	var err error
+
+	paths.chapters, err = CompilePath(keys.Chapters)
+	if err != nil {
+		return nil, errors.New("keys.chapters: " + err.Error())
+	}
+	paths.number, err = compileField(keys.Number)
+	if err != nil {
+		return nil, errors.New("keys.number: " + err.Error())
+	}
+	paths.title, err = compileField(keys.Title)
+	if err != nil {
+		return nil, errors.New("keys.title: " + err.Error())
+	}
+	paths.url, err = compileField(keys.Url)
+	if err != nil {
+		return nil, errors.New("keys.url: " + err.Error())
+	}
+	if keys.Date != "" {
+		paths.date, err = CompilePath(keys.Date)
+		if err != nil {
+			return nil, errors.New("keys.date: " + err.Error())
+		}
+	}
+	for _, optional := range []struct {
+		field **jsonField
+		key   string
+		name  string
+	}{
+		{&paths.thumbnail, keys.Thumbnail, "keys.thumbnail"},
+		{&paths.description, keys.Description, "keys.description"},
+		{&paths.author, keys.Author, "keys.author"},
+	} {
+		if optional.key == "" {
+			continue
+		}
+		*optional.field, err = compileField(optional.key)
+		if err != nil {
+			return nil, errors.New(optional.name + ": " + err.Error())
+		}
+	}
+	if keys.Free != "" {
+		paths.free, err = CompilePath(keys.Free)
+		if err != nil {
+			return nil, errors.New("keys.free: " + err.Error())
+		}
+	}
+	if keys.Paid != "" {
+		paths.paid, err = CompilePath(keys.Paid)
+		if err != nil {
+			return nil, errors.New("keys.paid: " + err.Error())
+		}
+	}
+	for key, value := range keys.Skip {
+		path, err := CompilePath(key)
+		if err != nil {
+			return nil, errors.New("keys.skip: " + err.Error())
+		}
+		paths.skip = append(paths.skip, jsonSkip{path: path, value: value})
+	}
+
+	return &paths, nil
+}
+
+// A value built from one or more paths, written either as a template like "/works/{work_id}/episodes/{id}",
+// or as paths joined by "+" like "volume+title" (which joins the values that aren't empty with a space).
+type jsonField struct {
+	literals []string // For templates: the text around the paths, one more than the paths
+	paths    []*Path
+	template bool
+}
+
+// Compiles a field. It's a template if it has any "{"; "{{" and "}}" are literal braces.
+func compileField(key string) (*jsonField, error) {
+	if !strings.Contains(key, "{") {
+		field := &jsonField{}
+		for _, component := range splitOutside(key, "+") {
+			path, err := CompilePath(strings.TrimSpace(component))
+			if err != nil {
+				return nil, err
+			}
+			field.paths = append(field.paths, path)
+		}
+		return field, nil
+	}
+
+	field := &jsonField{template: true}
+	var literal strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case strings.HasPrefix(key[i:], "{{"):
+			literal.WriteByte('{')
+			i++
+		case strings.HasPrefix(key[i:], "}}"):
+			literal.WriteByte('}')
+			i++
+		case key[i] == '{':
+			end := indexOutside(key[i+1:], "}")
+			if end < 0 {
+				return nil, errors.New("unclosed { in template " + key)
+			}
+			path, err := CompilePath(strings.TrimSpace(key[i+1 : i+1+end]))
+			if err != nil {
+				return nil, err
+			}
+			field.literals = append(field.literals, literal.String())
+			field.paths = append(field.paths, path)
+			literal.Reset()
+			i += end + 1
+		case key[i] == '}':
+			return nil, errors.New("unexpected } in template " + key)
+		default:
+			literal.WriteByte(key[i])
+		}
+	}
+	field.literals = append(field.literals, literal.String())
+
+	return field, nil
+}
+
+// Builds the value of a field from a chapter in the document. Paths that find nothing give an empty string.
+func (f *jsonField) render(data any, root any) string {
+	values := make([]string, len(f.paths))
+	for i, path := range f.paths {
+		value, _ := path.firstIn(data, root)
+		values[i] = formatValue(value)
+	}
+
+	if !f.template {
+		var components []string
+		for _, value := range values {
+			if value != "" {
+				components = append(components, value)
+			}
+		}
+		return strings.Join(components, " ")
+	}
+
+	var rendered strings.Builder
+	for i, value := range values {
+		rendered.WriteString(f.literals[i])
+		rendered.WriteString(value)
+	}
+	rendered.WriteString(f.literals[len(f.literals)-1])
+
+	return rendered.String()
+}
+
+// Checks whether a JSON value counts as true: anything but null, false, 0, "", "0" and "false".
+func isTruthy(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return value
+	case float64:
+		return value != 0
+	case string:
+		return value != "" && value != "0" && !strings.EqualFold(value, "false")
+	}
+
+	return true
+}
+
+// Formats a JSON value as text. Whole numbers are written without decimals, so episode 12 is "12" and not "1.2e+01".
+// Objects, arrays and null give an empty string.
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+			return strconv.FormatInt(int64(value), 10)
 		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
 	}
 
-	return strings.Join(components, " ")
+	return ""
 }
 
-// Traverse the given data map using dot notation and returns the value.
-func traverse(data map[string]any, key string) any {
-	traverse := strings.Split(key, ".")
-	for index, key := range traverse {
-		if index < len(traverse)-1 {
-			data = data[key].(map[string]any)
+// Collects the chapter items found by the chapters path.
+// An array that's found is unpacked, so both "episodes" and "volumes[*].episodes" give the episodes themselves.
+func collectChapterItems(found []any) []any {
+	var items []any
+	for _, value := range found {
+		if array, ok := value.([]any); ok {
+			items = append(items, array...)
 		} else {
-			return data[key]
+			items = append(items, value)
 		}
 	}
 
-	return nil
+	return items
 }
 
 // Parses the given JSON string using the target information and returns an array of Chapters.
 func ParseJson(target *types.Target, jsonString *string) ([]types.Chapter, error) {
+	paths, err := compileJsonPaths(&target.Keys)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := getDateParser(target, target.Keys.DateFormat, "keys.dateFormat")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
 	// Unpack the entire JSON
-	unmarshalled := make(map[string]any)
-	json.Unmarshal([]byte(*jsonString), &unmarshalled)
+	var unmarshalled any
+	err = json.Unmarshal([]byte(*jsonString), &unmarshalled)
+	if err != nil {
+		return nil, err
+	}
 
-	// Delve for the array of objects marked by targets.Keys.Chapters key
-	chaptersJson := traverse(unmarshalled, target.Keys.Chapters).([]any)
+	// Delve for the chapters marked by targets.Keys.Chapters key
+	// A plain path that finds nothing is most likely wrong; a wildcard or a filter may just have nothing to match
+	found := paths.chapters.Find(unmarshalled)
+	if len(found) < 1 && paths.chapters.IsDefinite() {
+		return nil, errors.New("keys.chapters does not lead to anything: " + target.Keys.Chapters)
+	}
+	chaptersJson := collectChapterItems(found)
 
 	// Collect chapters data into an array
-	collectData := func(chapterJson map[string]any) (types.Chapter, bool) {
+	collectData := func(chapterJson any) (types.Chapter, bool) {
 		chapter := types.Chapter{}
 
 		// Check for skip
-		for key, value := range target.Keys.Skip {
-			valueInJson := traverse(chapterJson, key)
-			if valueInJson == value {
+		for _, skip := range paths.skip {
+			valueInJson, exists := skip.path.firstIn(chapterJson, unmarshalled)
+			if exists && valuesEqual(valueInJson, skip.value) {
 				return chapter, true
 			}
 		}
 
 		// Get chapter data
 		chapter.Manga = target.Name
-		chapter.Title = parseComponent(chapterJson, target.Keys.Title)
-		chapter.Number = parseComponent(chapterJson, target.Keys.Number)
-		url := parseComponent(chapterJson, target.Keys.Url)
+		chapter.Title = paths.title.render(chapterJson, unmarshalled)
+		chapter.Number = paths.number.render(chapterJson, unmarshalled)
+		url := paths.url.render(chapterJson, unmarshalled)
 		chapter.Url = makeFullUrl(url, target.BaseUrl)
 
-		// If Date key is specified and it exists, use. If not, just use Now as the chapter's publish date
-		if target.Keys.Date != "" {
-			dateFormat := target.Keys.DateFormat
-			if dateFormat == "" {
-				dateFormat = "RFC3339"
-			}
-
-			var date time.Time
-			var err error
-			if dateFormat == "unix" {
-				timestamp, ok := traverse(chapterJson, target.Keys.Date).(float64)
-				if ok {
-					intTimestamp := int64(timestamp)
-					date = time.Unix(intTimestamp/1000, (intTimestamp%1000)*int64(time.Millisecond))
-				} else {
-					err = errors.New("unable to parse timestamp")
+		// Get the optional details
+		if paths.thumbnail != nil {
+			chapter.Thumbnail = makeFullUrl(paths.thumbnail.render(chapterJson, unmarshalled), target.BaseUrl)
+		}
+		if paths.description != nil {
+			chapter.Description = cleanDescription(paths.description.render(chapterJson, unmarshalled))
+		}
+		if paths.author != nil {
+			chapter.Author = strings.TrimSpace(paths.author.render(chapterJson, unmarshalled))
+		}
+		if paths.free != nil {
+			if value, exists := paths.free.firstIn(chapterJson, unmarshalled); exists {
+				chapter.Access = types.AccessPaid
+				if isTruthy(value) {
+					chapter.Access = types.AccessFree
 				}
-			} else if dateFormat == "RFC3339" {
-				dateString, ok := traverse(chapterJson, target.Keys.Date).(string)
-				if ok {
-					date, err = time.Parse(time.RFC3339, dateString)
-				} else {
-					err = errors.New("unable to parse RFC3339 date")
+			}
+		} else if paths.paid != nil {
+			if value, exists := paths.paid.firstIn(chapterJson, unmarshalled); exists {
+				chapter.Access = types.AccessFree
+				if isTruthy(value) {
+					chapter.Access = types.AccessPaid
 				}
-			} else {
-				err = errors.New("dateFormat is invalid: " + dateFormat)
 			}
+		}
 
-			if err != nil {
-				chapter.Date = time.Now()
-			} else {
+		// If Date key is specified and it can be parsed, use. If not, just use Now as the chapter's publish date
+		chapter.Date = now
+		if paths.date != nil {
+			value, _ := paths.date.firstIn(chapterJson, unmarshalled)
+			if date, err := dates.parse(value, now); err == nil {
 				chapter.Date = date
+			} else {
+				chapter.Defaulted = append(chapter.Defaulted, "Date")
 			}
 		} else {
-			chapter.Date = time.Now()
+			chapter.Defaulted = append(chapter.Defaulted, "Date")
 		}
 
 		return chapter, false
@@ -116,11 +331,11 @@ func ParseJson(target *types.Target, jsonString *string) ([]types.Chapter, error
 			index = len(chaptersJson) - 1 - i
 		}
 
-		chapter, skip := collectData(chaptersJson[index].(map[string]any))
+		chapter, skip := collectData(chaptersJson[index])
 		if !skip {
 			chapters = append(chapters, chapter)
 		}
 	}
 
-	return chapters, nil
+	return FilterChapters(target, chapters)
 }
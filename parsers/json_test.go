@@ -214,3 +214,229 @@ func TestJsonParserWithSkipKeys(t *testing.T) {
 		t.Error("Different last element", parsed[2], thirdChapter)
 	}
 }
+
+func TestJsonParserWithWrongChaptersKey(t *testing.T) {
+	// Prepare a pre-set JSON
+	testJson := `{ "comic": { "episodes": [] } }`
+	testTarget := types.Target{
+		Name: "JSON Test Manga",
+		Mode: "json",
+		Keys: types.Keys{
+			Chapters: "comic.chapters",
+			Number:   "volume",
+			Title:    "title",
+			Url:      "page_url",
+		},
+	}
+
+	// Parse; this should fail instead of panicking
+	_, err := ParseJson(&testTarget, &testJson)
+	if err == nil {
+		t.Error("Expected an error for a chapters key that leads nowhere")
+	}
+
+	testTarget.Keys.Chapters = "comic.episodes.list"
+	_, err = ParseJson(&testTarget, &testJson)
+	if err == nil {
+		t.Error("Expected an error for a chapters key that goes through an array")
+	}
+}
+
+func TestJsonParserDefaultedFields(t *testing.T) {
+	// Prepare a pre-set JSON where one of the chapters has no date
+	testJson := `
+	{
+		"episodes": [
+			{ "title": "Chapter 2", "date": "not a date", "url": "/2" },
+			{ "title": "Chapter 1", "date": "2022-09-27T10:00:00.000+09:00", "url": "/1" }
+		]
+	}`
+	testTarget := types.Target{
+		Name: "JSON Test Manga",
+		Mode: "json",
+		Keys: types.Keys{
+			Chapters: "episodes",
+			Number:   "title",
+			Title:    "title",
+			Date:     "date",
+			Url:      "url",
+		},
+	}
+
+	// Parse
+	parsed, err := ParseJson(&testTarget, &testJson)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(parsed) != 2 {
+		t.Fatal("Size mismatch: expected 2, found", len(parsed))
+	}
+
+	// Only the chapter without a valid date should be marked
+	if len(parsed[0].Defaulted) != 0 {
+		t.Error("Expected no defaulted fields, found", parsed[0].Defaulted)
+	}
+	if len(parsed[1].Defaulted) != 1 || parsed[1].Defaulted[0] != "Date" {
+		t.Error("Expected the date to be defaulted, found", parsed[1].Defaulted)
+	}
+}
+
+func TestJsonParserWithNestedEpisodes(t *testing.T) {
+	// Prepare a pre-set JSON where the episodes are split into volumes
+	testJson := `
+	{
+		"volumes": [
+			{
+				"episodes": [
+					{ "readable": true, "title": "Chapter 1", "path": "/1" },
+					{ "readable": false, "title": "Chapter 2", "path": "/2" }
+				]
+			},
+			{
+				"episodes": [
+					{ "readable": true, "title": "Chapter 3", "path": "/3" }
+				]
+			}
+		]
+	}`
+	testTarget := types.Target{
+		Name:            "JSON Test Manga",
+		Mode:            "json",
+		BaseUrl:         "https://example.com",
+		AscendingSource: true,
+		Keys: types.Keys{
+			Chapters: "volumes[*].episodes[?(@.readable==true)]",
+			Number:   "number|title",
+			Title:    "title",
+			Url:      "path",
+		},
+	}
+
+	// Parse
+	parsed, err := ParseJson(&testTarget, &testJson)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Compare array length
+	if len(parsed) != 2 {
+		t.Fatal("Size mismatch: expected 2, found", len(parsed))
+	}
+
+	// Check that the unreadable episode was filtered out, and the number fell back to the title
+	if parsed[0].Number != "Chapter 1" || parsed[1].Number != "Chapter 3" || parsed[1].Url != "https://example.com/3" {
+		t.Error("Different elements", parsed)
+	}
+}
+
+func TestJsonParserWithTemplates(t *testing.T) {
+	// Prepare a pre-set JSON with numeric values
+	testJson := `
+	{
+		"work_id": 8789,
+		"episodes": [
+			{ "id": 2, "episode": { "number": 2, "sub_title": "The Revolution", "free": false } },
+			{ "id": 1, "episode": { "number": 1.5, "sub_title": "The Pilot", "free": true } }
+		]
+	}`
+	testTarget := types.Target{
+		Name:    "JSON Test Manga",
+		Mode:    "json",
+		BaseUrl: "https://comic.pixiv.net",
+		Keys: types.Keys{
+			Chapters: "episodes",
+			Number:   "episode.number",
+			Title:    "第{episode.number}話 {episode.sub_title} {{free: {episode.free}}}",
+			Url:      "/works/{$.work_id}/episodes/{id}",
+		},
+	}
+
+	// Parse
+	parsed, err := ParseJson(&testTarget, &testJson)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Compare array length
+	if len(parsed) != 2 {
+		t.Fatal("Size mismatch: expected 2, found", len(parsed))
+	}
+
+	// Check that numbers and booleans were formatted, and the templates were filled in
+	if parsed[0].Number != "1.5" ||
+		parsed[0].Title != "第1.5話 The Pilot {free: true}" ||
+		parsed[0].Url != "https://comic.pixiv.net/works/8789/episodes/1" {
+		t.Error("Different first element", parsed[0])
+	}
+	if parsed[1].Number != "2" ||
+		parsed[1].Title != "第2話 The Revolution {free: false}" ||
+		parsed[1].Url != "https://comic.pixiv.net/works/8789/episodes/2" {
+		t.Error("Different second element", parsed[1])
+	}
+
+	// A template that isn't closed is an error
+	testTarget.Keys.Title = "第{episode.number話"
+	_, err = ParseJson(&testTarget, &testJson)
+	if err == nil {
+		t.Error("Expected an error for an unclosed template")
+	}
+}
+
+func TestJsonParserDetails(t *testing.T) {
+	// Prepare a pre-set JSON with prices
+	testJson := `{
+		"work": { "id": 77, "author": "Noowee" },
+		"episodes": [
+			{ "id": 3, "title": "Chapter 3", "summary": "<p>Third</p>", "price": 30 },
+			{ "id": 2, "title": "Chapter 2", "summary": "Second", "price": 0 },
+			{ "id": 1, "title": "Chapter 1" }
+		]
+	}`
+	testTarget := types.Target{
+		Name:    "JSON Test Manga",
+		Mode:    "json",
+		BaseUrl: "https://comic.com",
+		Keys: types.Keys{
+			Chapters:    "episodes",
+			Number:      "id",
+			Title:       "title",
+			Url:         "/episodes/{id}",
+			Thumbnail:   "/images/{$.work.id}/{id}.jpg",
+			Description: "summary",
+			Author:      "$.work.author",
+			Paid:        "price",
+		},
+	}
+
+	parsed, err := ParseJson(&testTarget, &testJson)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(parsed) != 3 {
+		t.Fatal("Size mismatch: expected 3, found", len(parsed))
+	}
+
+	for index, expected := range []types.Chapter{
+		{Thumbnail: "https://comic.com/images/77/1.jpg", Author: "Noowee"},
+		{Thumbnail: "https://comic.com/images/77/2.jpg", Author: "Noowee", Description: "Second", Access: types.AccessFree},
+		{Thumbnail: "https://comic.com/images/77/3.jpg", Author: "Noowee", Description: "Third", Access: types.AccessPaid},
+	} {
+		if parsed[index].Thumbnail != expected.Thumbnail ||
+			parsed[index].Description != expected.Description ||
+			parsed[index].Author != expected.Author ||
+			parsed[index].Access != expected.Access {
+			t.Error("Different details of element", index, parsed[index], expected)
+		}
+	}
+
+	// Free is the other way around
+	testTarget.Keys.Paid = ""
+	testTarget.Keys.Free = "price"
+	parsed, err = ParseJson(&testTarget, &testJson)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if parsed[0].Access != "" || parsed[1].Access != types.AccessPaid || parsed[2].Access != types.AccessFree {
+		t.Error("Expected unknown, paid and free, found", parsed[0].Access, parsed[1].Access, parsed[2].Access)
+	}
+}
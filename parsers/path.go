@@ -0,0 +1,515 @@
+// This is the path language used by JSON mode to find values, e.g. in keys.chapters or keys.title.
+//
+//	comic.episodes                    keys, separated by dots
+//	volumes[0].episodes               an item of an array (negative indexes count from the end)
+//	volumes[*].episodes               every item of an array (or every value of an object)
+//	episodes[?(@.readable==true)]     the items that match a filter; == != < <= > >= && || are supported,
+//	                                  and [?(@.key)] matches the items where the key exists and isn't false or null
+//	["key.with.dots"]                 a key that can't be written plainly
+//	data.episodes|episodes            alternatives; the first one that finds anything is used
+//	$.work_id                         a path from the top of the document, where paths are otherwise relative
+//	                                  (like the keys of a chapter, which start from the chapter)
+//
+// A path that leads nowhere finds nothing instead of failing.
+
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A compiled path.
+type Path struct {
+	alternatives [][]pathStep
+}
+
+type pathStepKind int
+
+const (
+	stepKey pathStepKind = iota
+	stepRoot
+	stepIndex
+	stepWildcard
+	stepFilter
+)
+
+type pathStep struct {
+	kind   pathStepKind
+	key    string
+	index  int
+	filter *pathFilter
+}
+
+// A filter is a list of conditions joined by "||", each being a list of comparisons joined by "&&".
+type pathFilter struct {
+	any [][]pathComparison
+}
+
+type pathComparison struct {
+	path     []pathStep
+	operator string // Empty if the comparison only checks that the value exists
+	value    any
+}
+
+// Compiles a path expression.
+func CompilePath(expression string) (*Path, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, errors.New("the path is empty")
+	}
+
+	path := &Path{}
+	for _, alternative := range splitOutside(expression, "|") {
+		steps, err := parseSteps(strings.TrimSpace(alternative))
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", expression, err)
+		}
+		path.alternatives = append(path.alternatives, steps)
+	}
+
+	return path, nil
+}
+
+// Finds every value the path leads to, using the first alternative that finds anything.
+func (p *Path) Find(data any) []any {
+	return p.findIn(data, data)
+}
+
+// Finds the first value the path leads to. Returns false if there's none.
+func (p *Path) First(data any) (any, bool) {
+	return p.firstIn(data, data)
+}
+
+// Same as Find, but starting from a value inside the document; "$" still leads to the top of the document.
+func (p *Path) findIn(data any, root any) []any {
+	for _, steps := range p.alternatives {
+		found := walkSteps([]any{data}, steps, root)
+		if len(found) > 0 {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func (p *Path) firstIn(data any, root any) (any, bool) {
+	found := p.findIn(data, root)
+	if len(found) < 1 {
+		return nil, false
+	}
+
+	return found[0], true
+}
+
+// Checks whether the path can only lead to a single value, i.e. it has no wildcards or filters.
+func (p *Path) IsDefinite() bool {
+	for _, steps := range p.alternatives {
+		for _, step := range steps {
+			if step.kind == stepWildcard || step.kind == stepFilter {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Applies the steps on every value, and collects what they lead to.
+// Values that don't exist (like a missing key or an index out of range) are dropped.
+func walkSteps(values []any, steps []pathStep, root any) []any {
+	for _, step := range steps {
+		if step.kind == stepRoot {
+			values = []any{root}
+			continue
+		}
+
+		var next []any
+		for _, value := range values {
+			switch step.kind {
+			case stepKey:
+				if object, ok := value.(map[string]any); ok {
+					if child, exists := object[step.key]; exists {
+						next = append(next, child)
+					}
+				}
+
+			case stepIndex:
+				if array, ok := value.([]any); ok {
+					index := step.index
+					if index < 0 {
+						index += len(array)
+					}
+					if index >= 0 && index < len(array) {
+						next = append(next, array[index])
+					}
+				}
+
+			case stepWildcard:
+				next = append(next, getChildren(value)...)
+
+			case stepFilter:
+				for _, child := range getChildren(value) {
+					if step.filter.matches(child, root) {
+						next = append(next, child)
+					}
+				}
+			}
+		}
+		values = next
+	}
+
+	return values
+}
+
+// Gets the items of an array, or the values of an object ordered by their keys.
+func getChildren(value any) []any {
+	switch value := value.(type) {
+	case []any:
+		return value
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		children := make([]any, 0, len(keys))
+		for _, key := range keys {
+			children = append(children, value[key])
+		}
+		return children
+	}
+
+	return nil
+}
+
+func (f *pathFilter) matches(value any, root any) bool {
+	for _, all := range f.any {
+		matched := true
+		for _, comparison := range all {
+			if !comparison.matches(value, root) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *pathComparison) matches(value any, root any) bool {
+	found := walkSteps([]any{value}, c.path, root)
+	if c.operator == "" {
+		return len(found) > 0 && found[0] != nil && found[0] != false
+	}
+	if len(found) < 1 {
+		return c.operator == "!="
+	}
+
+	actual := found[0]
+	switch c.operator {
+	case "==":
+		return valuesEqual(actual, c.value)
+	case "!=":
+		return !valuesEqual(actual, c.value)
+	}
+
+	// The other operators compare numbers, or strings
+	if a, ok := toNumber(actual); ok {
+		if b, ok := toNumber(c.value); ok {
+			return compareOrdered(a, b, c.operator)
+		}
+	}
+	if a, ok := actual.(string); ok {
+		if b, ok := c.value.(string); ok {
+			return compareOrdered(a, b, c.operator)
+		}
+	}
+
+	return false
+}
+
+func compareOrdered[T float64 | string](a T, b T, operator string) bool {
+	switch operator {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+
+	return false
+}
+
+// Checks whether two values are equal, treating numbers of different types (e.g. from JSON and from TOML) as the same.
+func valuesEqual(a any, b any) bool {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return x == y
+		}
+		return false
+	}
+
+	switch a.(type) {
+	case string, bool, nil:
+		return a == b
+	}
+
+	return false
+}
+
+func toNumber(value any) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	}
+
+	return 0, false
+}
+
+// Parses the steps of a single path, e.g. "data.episodes[0]".
+func parseSteps(expression string) ([]pathStep, error) {
+	var steps []pathStep
+
+	// The top of the document is written as "$", like in JSONPath
+	rest := expression
+	if rest == "$" || strings.HasPrefix(rest, "$.") || strings.HasPrefix(rest, "$[") {
+		steps = append(steps, pathStep{kind: stepRoot})
+		rest = rest[1:]
+	}
+	rest = strings.TrimPrefix(rest, ".")
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := findClosingBracket(rest)
+			if end < 0 {
+				return nil, errors.New("unclosed [")
+			}
+			step, err := parseBracket(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			rest = rest[end+1:]
+
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			if rest == "" || strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "[") {
+				return nil, errors.New("a key is missing after a dot")
+			}
+
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if strings.ContainsAny(key, "]()") {
+				return nil, errors.New("unexpected character in key " + strconv.Quote(key))
+			}
+			if key == "*" {
+				steps = append(steps, pathStep{kind: stepWildcard})
+			} else {
+				steps = append(steps, pathStep{kind: stepKey, key: key})
+			}
+			rest = rest[end:]
+		}
+	}
+
+	return steps, nil
+}
+
+// Parses what's inside brackets: an index, a wildcard, a quoted key or a filter.
+func parseBracket(inside string) (pathStep, error) {
+	switch {
+	case inside == "*":
+		return pathStep{kind: stepWildcard}, nil
+
+	case strings.HasPrefix(inside, "?"):
+		expression := strings.TrimSpace(inside[1:])
+		if !strings.HasPrefix(expression, "(") || !strings.HasSuffix(expression, ")") {
+			return pathStep{}, errors.New("a filter must be written as [?(...)]")
+		}
+		filter, err := parseFilter(expression[1 : len(expression)-1])
+		if err != nil {
+			return pathStep{}, err
+		}
+		return pathStep{kind: stepFilter, filter: filter}, nil
+
+	case strings.HasPrefix(inside, `"`) || strings.HasPrefix(inside, "'"):
+		key, err := unquote(inside)
+		if err != nil {
+			return pathStep{}, err
+		}
+		return pathStep{kind: stepKey, key: key}, nil
+	}
+
+	index, err := strconv.Atoi(inside)
+	if err != nil {
+		return pathStep{}, errors.New("invalid index " + strconv.Quote(inside))
+	}
+	return pathStep{kind: stepIndex, index: index}, nil
+}
+
+// The comparison operators, longest first so "<=" isn't read as "<".
+var pathOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Parses a filter expression, e.g. "@.readable==true && @.price<1".
+func parseFilter(expression string) (*pathFilter, error) {
+	filter := &pathFilter{}
+
+	for _, alternative := range splitOutside(expression, "||") {
+		var all []pathComparison
+		for _, condition := range splitOutside(alternative, "&&") {
+			comparison, err := parseComparison(strings.TrimSpace(condition))
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, comparison)
+		}
+		filter.any = append(filter.any, all)
+	}
+
+	return filter, nil
+}
+
+func parseComparison(condition string) (pathComparison, error) {
+	var comparison pathComparison
+
+	left := condition
+	for _, operator := range pathOperators {
+		if at := indexOutside(condition, operator); at >= 0 {
+			left = strings.TrimSpace(condition[:at])
+			comparison.operator = operator
+
+			value, err := parseLiteral(strings.TrimSpace(condition[at+len(operator):]))
+			if err != nil {
+				return comparison, err
+			}
+			comparison.value = value
+			break
+		}
+	}
+
+	if !strings.HasPrefix(left, "@") {
+		return comparison, errors.New("a filter condition must start with @, e.g. @.readable==true")
+	}
+	path, err := parseSteps(left[1:])
+	if err != nil {
+		return comparison, err
+	}
+	comparison.path = path
+
+	return comparison, nil
+}
+
+// Parses a literal in a filter: a quoted string, a number, true, false or null.
+func parseLiteral(literal string) (any, error) {
+	switch literal {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	if strings.HasPrefix(literal, `"`) || strings.HasPrefix(literal, "'") {
+		return unquote(literal)
+	}
+
+	number, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, errors.New("invalid value " + strconv.Quote(literal) + " in filter")
+	}
+	return number, nil
+}
+
+// Removes the quotes around a string. Single quotes are allowed too.
+func unquote(quoted string) (string, error) {
+	if len(quoted) < 2 || quoted[0] != quoted[len(quoted)-1] {
+		return "", errors.New("unclosed quote in " + quoted)
+	}
+
+	return quoted[1 : len(quoted)-1], nil
+}
+
+// Finds the end of the bracket the string starts with, skipping nested brackets and quoted strings.
+func findClosingBracket(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Finds a separator that's not inside brackets or quotes. Returns -1 if there's none.
+func indexOutside(s string, separator string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], separator):
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Splits a string by a separator that's not inside brackets or quotes.
+func splitOutside(s string, separator string) []string {
+	var parts []string
+	for {
+		at := indexOutside(s, separator)
+		if at < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:at])
+		s = s[at+len(separator):]
+	}
+}
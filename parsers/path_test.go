@@ -0,0 +1,96 @@
+package parsers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const pathTestJson = `
+{
+	"data": {
+		"volumes": [
+			{
+				"title": "Volume 1",
+				"episodes": [
+					{ "id": 1, "readable": true, "price": 0, "title": "Chapter 1" },
+					{ "id": 2, "readable": false, "price": 30, "title": "Chapter 2" }
+				]
+			},
+			{
+				"title": "Volume 2",
+				"episodes": [
+					{ "id": 3, "readable": true, "price": 30, "title": "Chapter 3" }
+				]
+			}
+		],
+		"key.with.dots": "dotted"
+	}
+}`
+
+func findWithPath(t *testing.T, data any, expression string) []any {
+	t.Helper()
+
+	path, err := CompilePath(expression)
+	if err != nil {
+		t.Fatal("Could not compile", expression+":", err.Error())
+	}
+
+	return path.Find(data)
+}
+
+func TestPath(t *testing.T) {
+	var data any
+	if err := json.Unmarshal([]byte(pathTestJson), &data); err != nil {
+		t.Fatal("The test itself failed (JSON parsing)")
+	}
+
+	cases := []struct {
+		expression string
+		expected   []any
+	}{
+		{"data.volumes[0].title", []any{"Volume 1"}},
+		{"$.data.volumes[-1].title", []any{"Volume 2"}},
+		{"data.volumes[*].title", []any{"Volume 1", "Volume 2"}},
+		{"data.volumes[*].episodes[*].id", []any{1.0, 2.0, 3.0}},
+		{"data.volumes[*].episodes[?(@.readable==true)].id", []any{1.0, 3.0}},
+		{"data.volumes[*].episodes[?(@.readable)].id", []any{1.0, 3.0}},
+		{"data.volumes[*].episodes[?(@.price>0 && @.readable==true)].id", []any{3.0}},
+		{"data.volumes[*].episodes[?(@.id==1 || @.id==2)].id", []any{1.0, 2.0}},
+		{"data.volumes[*].episodes[?(@.title=='Chapter 2')].id", []any{2.0}},
+		{`data["key.with.dots"]`, []any{"dotted"}},
+		{"data.chapters|data.volumes[1].title", []any{"Volume 2"}},
+		{"data.volumes[5].title", nil},
+		{"data.missing.key", nil},
+		{"data.volumes.title", nil},
+	}
+
+	for _, c := range cases {
+		found := findWithPath(t, data, c.expression)
+		if len(found) != len(c.expected) {
+			t.Error(c.expression+": expected", c.expected, "found", found)
+			continue
+		}
+		for i := range found {
+			if found[i] != c.expected[i] {
+				t.Error(c.expression+": expected", c.expected, "found", found)
+				break
+			}
+		}
+	}
+}
+
+func TestPathErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"data.volumes[0",
+		"data.volumes[x]",
+		"data..volumes",
+		"data.volumes[?(readable==true)]",
+		"data.volumes[?(@.readable==yes)]",
+		"data.volumes[?@.readable]",
+	} {
+		if _, err := CompilePath(expression); err == nil {
+			t.Error("Expected an error for", expression)
+		}
+	}
+}
@@ -4,12 +4,56 @@ package parsers
 
 import (
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hermitpopcorn/decatholac-mango/types"
 	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
 )
 
+// Gets the image of a feed item: its media:thumbnail, an image in its media:content or enclosures, or its iTunes image.
+func getItemThumbnail(item *gofeed.Item) string {
+	media := item.Extensions["media"]
+	for _, group := range media["group"] {
+		if url := getMediaImage(group.Children); url != "" {
+			return url
+		}
+	}
+	if url := getMediaImage(media); url != "" {
+		return url
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") && enclosure.URL != "" {
+			return enclosure.URL
+		}
+	}
+
+	if item.Image != nil {
+		return item.Image.URL
+	}
+
+	return ""
+}
+
+// Gets the image in media:thumbnail or media:content elements.
+func getMediaImage(media map[string][]ext.Extension) string {
+	for _, thumbnail := range media["thumbnail"] {
+		if url := thumbnail.Attrs["url"]; url != "" {
+			return url
+		}
+	}
+	for _, content := range media["content"] {
+		isImage := content.Attrs["medium"] == "image" || strings.HasPrefix(content.Attrs["type"], "image/")
+		if url := content.Attrs["url"]; isImage && url != "" {
+			return url
+		}
+	}
+
+	return ""
+}
+
 // Parses the given RSS string using the target information and returns an array of Chapters.
 func ParseRss(target *types.Target, rssString *string) ([]types.Chapter, error) {
 	// Parse RSS string into a feed object
@@ -19,6 +63,13 @@ func ParseRss(target *types.Target, rssString *string) ([]types.Chapter, error)
 		return nil, err
 	}
 
+	// Only used for dates the feed parser doesn't understand
+	dates, err := getDateParser(target, "", "dateFormat")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
 	// Closure to build a Chapter object from a feed entry
 	collectData := func(chapterFeedItem gofeed.Item, counter uint64) types.Chapter {
 		chapter := types.Chapter{}
@@ -31,16 +82,27 @@ func ParseRss(target *types.Target, rssString *string) ([]types.Chapter, error)
 			chapter.Number = chapterFeedItem.GUID
 		} else {
 			chapter.Number = strconv.FormatUint(counter, 10)
+			chapter.Defaulted = append(chapter.Defaulted, "Number")
 		}
 
 		url := chapterFeedItem.Link
 		chapter.Url = makeFullUrl(url, target.BaseUrl)
 
-		// If Date key is specified and it exists, use. If not, just use Now as the chapter's publish date
-		if len(chapterFeedItem.Published) > 0 {
+		// Get the optional details
+		chapter.Thumbnail = makeFullUrl(getItemThumbnail(&chapterFeedItem), target.BaseUrl)
+		chapter.Description = cleanDescription(chapterFeedItem.Description)
+		if chapterFeedItem.Author != nil {
+			chapter.Author = strings.TrimSpace(chapterFeedItem.Author.Name)
+		}
+
+		// If the publish date exists, use. If not, just use Now as the chapter's publish date
+		if chapterFeedItem.PublishedParsed != nil {
 			chapter.Date = *chapterFeedItem.PublishedParsed
+		} else if date, err := dates.parse(chapterFeedItem.Published, now); err == nil {
+			chapter.Date = date
 		} else {
-			chapter.Date = time.Now()
+			chapter.Date = now
+			chapter.Defaulted = append(chapter.Defaulted, "Date")
 		}
 
 		return chapter
@@ -57,5 +119,5 @@ func ParseRss(target *types.Target, rssString *string) ([]types.Chapter, error)
 		chapters = append(chapters, chapter)
 	}
 
-	return chapters, nil
+	return FilterChapters(target, chapters)
 }
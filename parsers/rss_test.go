@@ -92,3 +92,63 @@ func TestRssParser(t *testing.T) {
 		t.Error("Different second element", parsed[1], secondChapter)
 	}
 }
+
+func TestRssParserDetails(t *testing.T) {
+	// Prepare a pre-set RSS with media thumbnails, image enclosures and HTML descriptions
+	testRss := `<?xml version="1.0"?>
+	<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<channel>
+			<title>RSS Test Publishing</title>
+			<item>
+				<title>Part 25: The Beta</title>
+				<link>https://comic-rss.com/episode/00025</link>
+				<guid isPermalink="false">00025</guid>
+				<description><![CDATA[<p>The <b>Beta</b>  arrives.</p>]]></description>
+				<dc:creator>Noowee</dc:creator>
+				<media:thumbnail url="https://cdn-img.comic-rss.com/thumbnail/25" />
+				<enclosure url="https://cdn-img.comic-rss.com/public/episode-thumbnail/25" length="0" type="image/jpeg" />
+			</item>
+			<item>
+				<title>Part 24: The Omega</title>
+				<link>https://comic-rss.com/episode/00024</link>
+				<guid isPermalink="false">00024</guid>
+				<enclosure url="https://cdn-img.comic-rss.com/audio/24" length="0" type="audio/mpeg" />
+				<enclosure url="https://cdn-img.comic-rss.com/public/episode-thumbnail/24" length="0" type="image/jpeg" />
+				<author>Noowee</author>
+			</item>
+			<item>
+				<title>Part 23: The Alpha</title>
+				<link>https://comic-rss.com/episode/00023</link>
+				<guid isPermalink="false">00023</guid>
+				<media:group>
+					<media:content url="https://cdn-img.comic-rss.com/video/23" type="video/mp4" />
+					<media:content url="https://cdn-img.comic-rss.com/cover/23" medium="image" />
+				</media:group>
+			</item>
+		</channel>
+	</rss>`
+	testTarget := types.Target{
+		Name: "RSS Test Publishing",
+		Mode: "rss",
+	}
+
+	parsed, err := ParseRss(&testTarget, &testRss)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(parsed) != 3 {
+		t.Fatal("Size mismatch: expected 3, found", len(parsed))
+	}
+
+	for index, expected := range []types.Chapter{
+		{Thumbnail: "https://cdn-img.comic-rss.com/cover/23"},
+		{Thumbnail: "https://cdn-img.comic-rss.com/public/episode-thumbnail/24", Author: "Noowee"},
+		{Thumbnail: "https://cdn-img.comic-rss.com/thumbnail/25", Author: "Noowee", Description: "The Beta arrives."},
+	} {
+		if parsed[index].Thumbnail != expected.Thumbnail ||
+			parsed[index].Author != expected.Author ||
+			parsed[index].Description != expected.Description {
+			t.Error("Different details of element", index, parsed[index], expected)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// This checks targets for settings their parser can't work with,
+// so a typo shows up when the config is loaded instead of as a target that silently finds nothing.
+
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// A problem with a target. Field is the key in the target's config, e.g. "keys.chapters".
+type TargetProblem struct {
+	Field   string
+	Message string
+}
+
+// Checks a target and returns every problem found with it.
+func ValidateTarget(target *types.Target) []TargetProblem {
+	var problems []TargetProblem
+	add := func(field string, message string) {
+		problems = append(problems, TargetProblem{Field: field, Message: message})
+	}
+
+	if target.Name == "" {
+		add("name", "is required")
+	}
+
+	if target.Source == "" {
+		add("source", "is required")
+	} else if !isAbsoluteUrl(target.Source) {
+		add("source", "must be an http(s) URL, not \""+target.Source+"\"")
+	}
+	if target.BaseUrl != "" && !isAbsoluteUrl(target.BaseUrl) {
+		add("baseUrl", "must be an http(s) URL, not \""+target.BaseUrl+"\"")
+	}
+
+	if target.Color != "" {
+		if _, err := ParseColor(target.Color); err != nil {
+			add("color", err.Error())
+		}
+	}
+	if target.Icon != "" && !isAbsoluteUrl(target.Icon) {
+		add("icon", "must be an http(s) URL, not \""+target.Icon+"\"")
+	}
+
+	hasKeys := target.Keys.Chapters != "" || target.Keys.Number != "" || target.Keys.Title != "" ||
+		target.Keys.Date != "" || target.Keys.DateFormat != "" || target.Keys.Url != "" || len(target.Keys.Skip) > 0 ||
+		target.Keys.Thumbnail != "" || target.Keys.Description != "" || target.Keys.Author != "" ||
+		target.Keys.Free != "" || target.Keys.Paid != ""
+	hasTags := target.Tags != (types.Tags{})
+
+	if _, err := loadTimezone(target.Timezone); err != nil {
+		add("timezone", err.Error())
+	}
+
+	for index := range target.Filters.Include {
+		if _, err := compileFilter(&target.Filters.Include[index]); err != nil {
+			add(fmt.Sprintf("filters.include[%d]", index), err.Error())
+		}
+	}
+	for index := range target.Filters.Exclude {
+		if _, err := compileFilter(&target.Filters.Exclude[index]); err != nil {
+			add(fmt.Sprintf("filters.exclude[%d]", index), err.Error())
+		}
+	}
+
+	switch target.Mode {
+	case "json":
+		if target.Keys.Chapters == "" {
+			add("keys.chapters", "is required in json mode")
+		}
+		if target.Keys.Number == "" {
+			add("keys.number", "is required in json mode")
+		}
+		if target.Keys.Title == "" {
+			add("keys.title", "is required in json mode")
+		}
+		if target.Keys.Url == "" {
+			add("keys.url", "is required in json mode")
+		}
+		for _, key := range []struct{ field, path string }{
+			{"keys.chapters", target.Keys.Chapters},
+			{"keys.number", target.Keys.Number},
+			{"keys.title", target.Keys.Title},
+			{"keys.date", target.Keys.Date},
+			{"keys.url", target.Keys.Url},
+			{"keys.thumbnail", target.Keys.Thumbnail},
+			{"keys.description", target.Keys.Description},
+			{"keys.author", target.Keys.Author},
+		} {
+			if key.path == "" {
+				continue
+			}
+			if _, err := compileField(key.path); err != nil {
+				add(key.field, err.Error())
+			}
+		}
+		for _, key := range []struct{ field, path string }{
+			{"keys.free", target.Keys.Free},
+			{"keys.paid", target.Keys.Paid},
+		} {
+			if key.path == "" {
+				continue
+			}
+			if _, err := CompilePath(key.path); err != nil {
+				add(key.field, err.Error())
+			}
+		}
+		if target.Keys.Free != "" && target.Keys.Paid != "" {
+			add("keys.paid", "can't be used together with keys.free")
+		}
+		for key := range target.Keys.Skip {
+			if _, err := CompilePath(key); err != nil {
+				add("keys.skip", err.Error())
+			}
+		}
+		if target.Keys.DateFormat != "" {
+			if target.Keys.Date == "" {
+				add("keys.dateFormat", "is set, but there's no keys.date to use it on")
+			}
+			if _, err := compileDateFormat(target.Keys.DateFormat, time.UTC); err != nil {
+				add("keys.dateFormat", err.Error())
+			}
+		}
+		if hasTags {
+			add("tags", "is only used in html mode")
+		}
+
+	case "html":
+		if target.Tags.ChaptersTag == "" {
+			add("tags.chaptersTag", "is required in html mode")
+		}
+		if target.Tags.DateTag != "" {
+			if _, err := compileDateFormat(target.Tags.DateFormat, time.UTC); err != nil {
+				add("tags.dateFormat", err.Error())
+			}
+		} else if target.Tags.DateFormat != "" {
+			add("tags.dateFormat", "is set, but there's no tags.dateTag to use it on")
+		}
+		if hasKeys {
+			add("keys", "is only used in json mode")
+		}
+
+	case "rss":
+		if hasKeys {
+			add("keys", "is only used in json mode")
+		}
+		if hasTags {
+			add("tags", "is only used in html mode")
+		}
+
+	case "":
+		add("mode", "is required (json, rss or html)")
+
+	default:
+		add("mode", "must be json, rss or html, not \""+target.Mode+"\"")
+	}
+
+	return problems
+}
+
+// Checks whether the string is an absolute http or https URL.
+func isAbsoluteUrl(value string) bool {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Parses a color like "#e4007f" (the # is optional) into the number Discord wants.
+func ParseColor(color string) (int, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) != 6 {
+		return 0, errors.New("must be a hex color like \"#e4007f\", not \"" + color + "\"")
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, errors.New("must be a hex color like \"#e4007f\", not \"" + color + "\"")
+	}
+	return int(value), nil
+}
+
+// Checks whether a Go date layout has anything in it to parse.
+// A layout without any of the reference date's parts formats any date to itself.
+func isDateLayout(layout string) bool {
+	date := time.Date(1999, time.December, 31, 23, 59, 58, 0, time.UTC)
+	return date.Format(layout) != layout
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
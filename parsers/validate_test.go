@@ -0,0 +1,130 @@
+package parsers
+
+import (
+	"testing"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// Checks that the problems found are exactly the expected fields, in any order.
+func expectProblems(t *testing.T, problems []TargetProblem, fields ...string) {
+	t.Helper()
+
+	found := make(map[string]bool)
+	for _, problem := range problems {
+		found[problem.Field] = true
+	}
+	for _, field := range fields {
+		if !found[field] {
+			t.Error("Expected a problem with", field, "but found", problems)
+		}
+	}
+	if len(problems) != len(fields) {
+		t.Error("Expected", len(fields), "problem(s), found", problems)
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	// A valid target of each mode
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "JSON Test Manga",
+		Source: "https://mangacross.jp/api/comics/yabai.json",
+		Mode:   "json",
+		Keys: types.Keys{
+			Chapters:   "comic.episodes",
+			Number:     "volume",
+			Title:      "volume+title",
+			Date:       "publish_start",
+			DateFormat: "unix",
+			Url:        "page_url",
+		},
+	}))
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "RSS Test Manga",
+		Color:  "#e4007f",
+		Icon:   "https://comic-zenon.com/favicon.png",
+		Source: "https://comic-zenon.com/rss/series/13933686331687311931",
+		Mode:   "rss",
+	}))
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "HTML Test Manga",
+		Source: "https://example.com/manga",
+		Mode:   "html",
+		Tags: types.Tags{
+			ChaptersTag: "li.chapter",
+			DateTag:     ".date",
+			DateFormat:  "2006/01/02",
+		},
+	}))
+}
+
+func TestValidateTargetProblems(t *testing.T) {
+	// A typo in the mode
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "Typo",
+		Source: "https://example.com/api",
+		Mode:   "jsn",
+	}), "mode")
+
+	// Missing keys in JSON mode, and an unknown date format
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "Missing keys",
+		Source: "https://example.com/api",
+		Mode:   "json",
+		Keys: types.Keys{
+			Title:      "title",
+			Date:       "date",
+			DateFormat: "yyyy-mm-dd",
+		},
+	}), "keys.chapters", "keys.number", "keys.url", "keys.dateFormat")
+
+	// An invalid date layout, and keys that are only used in JSON mode
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "Bad layout",
+		Source: "https://example.com/manga",
+		Mode:   "html",
+		Keys:   types.Keys{Chapters: "episodes"},
+		Tags: types.Tags{
+			ChaptersTag: "li",
+			DateTag:     ".date",
+			DateFormat:  "YYYY/MM/DD",
+		},
+	}), "tags.dateFormat", "keys")
+
+	// An unknown timezone, and a strftime pattern with a directive that isn't supported
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:     "Bad dates",
+		Source:   "https://example.com/manga",
+		Mode:     "html",
+		Timezone: "JST",
+		Tags: types.Tags{
+			ChaptersTag: "li",
+			DateTag:     ".date",
+			DateFormat:  "%Y/%m/%d %X",
+		},
+	}), "timezone", "tags.dateFormat")
+
+	// A color and an icon that won't work, and both free and paid keys
+	expectProblems(t, ValidateTarget(&types.Target{
+		Name:   "Bad branding",
+		Source: "https://example.com/api",
+		Mode:   "json",
+		Color:  "pink",
+		Icon:   "icon.png",
+		Keys: types.Keys{
+			Chapters: "episodes",
+			Number:   "id",
+			Title:    "title",
+			Url:      "url",
+			Free:     "is_free",
+			Paid:     "price",
+		},
+	}), "color", "icon", "keys.paid")
+
+	// No name, and URLs that aren't
+	expectProblems(t, ValidateTarget(&types.Target{
+		Source:  "example.com/rss",
+		BaseUrl: "/manga",
+		Mode:    "rss",
+	}), "name", "source", "baseUrl")
+}
@@ -0,0 +1,136 @@
+// This file handles the scheduling of gofers.
+// Targets without a schedule of their own are fetched together by the global cronjob,
+// while targets with a schedule get a cronjob of their own.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+	"github.com/robfig/cron/v3"
+)
+
+// Turns a schedule into a cron spec.
+// Plain durations like "6h" or "30m" are turned into "@every" specs,
+// anything else is assumed to be a cron spec or a descriptor already.
+func toCronSpec(schedule string) string {
+	schedule = strings.TrimSpace(schedule)
+	if _, err := time.ParseDuration(schedule); err == nil {
+		return "@every " + schedule
+	}
+
+	return schedule
+}
+
+// Splits the targets into the ones that follow the global schedule and the ones that have their own.
+func splitTargetsBySchedule(targets []types.Target) (unscheduled []types.Target, scheduled []types.Target) {
+	for _, target := range targets {
+		if strings.TrimSpace(target.Schedule) == "" {
+			unscheduled = append(unscheduled, target)
+		} else {
+			scheduled = append(scheduled, target)
+		}
+	}
+
+	return unscheduled, scheduled
+}
+
+// Announces to every guild, if there's a Discord session to announce with.
+func announceIfPossible(ctx context.Context, trigger string) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	if session != nil {
+		fmt.Println(helpers.FormattedNow(), "Global announcement process triggered by", trigger)
+		startAnnouncers(ctx, db)
+	} else {
+		fmt.Println(helpers.FormattedNow(), "Global announcement process halted: no Discord session")
+	}
+}
+
+// Keeps the cronjobs of the gofers, so they can be replaced whenever the targets change.
+type goferScheduler struct {
+	lock    sync.Mutex
+	cron    *cron.Cron
+	entries []cron.EntryID
+}
+
+var scheduler = &goferScheduler{cron: cron.New()}
+
+// Registers the global cronjob and one cronjob for every target with its own schedule,
+// replacing the previously registered ones. Jobs that are currently running are not interrupted.
+// If the interval is invalid, the previous jobs are kept; a target with an invalid schedule is left out.
+func (s *goferScheduler) reschedule(interval string, targets []types.Target) error {
+	if _, err := cron.ParseStandard(toCronSpec(interval)); err != nil {
+		return fmt.Errorf("invalid cronInterval %q: %w", interval, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, entry := range s.entries {
+		s.cron.Remove(entry)
+	}
+	s.entries = nil
+
+	unscheduled, scheduled := splitTargetsBySchedule(targets)
+
+	// The global job fetches every target without a schedule, then announces
+	job := func() {
+		runWork(func(ctx context.Context) {
+			fmt.Println(helpers.FormattedNow(), "Fetch process triggered by cronjob")
+			startGofers(ctx, db, &unscheduled)
+			announceIfPossible(ctx, "cronjob")
+		})
+	}
+	entry, err := s.cron.AddFunc(toCronSpec(interval), job)
+	if err != nil {
+		return fmt.Errorf("invalid cronInterval %q: %w", interval, err)
+	}
+	s.entries = append(s.entries, entry)
+
+	// The other jobs fetch their own target, and only announce if it found something new
+	for _, target := range scheduled {
+		target := target
+		spec := toCronSpec(target.Schedule)
+		entry, err := s.cron.AddFunc(spec, func() {
+			runWork(func(ctx context.Context) {
+				fmt.Println(helpers.FormattedNow(), "Fetch process for", target.Name, "triggered by its schedule")
+				inserted, err := startGofer(ctx, db, target)
+				if err != nil {
+					fmt.Println(helpers.FormattedNow(), target.Name+":", err.Error())
+					return
+				}
+
+				if inserted > 0 {
+					announceIfPossible(ctx, target.Name+"'s schedule")
+				}
+			})
+		})
+		if err != nil {
+			fmt.Println(helpers.FormattedNow(), "Invalid schedule", target.Schedule, "for target", target.Name+":", err.Error())
+			continue
+		}
+		s.entries = append(s.entries, entry)
+		fmt.Println(helpers.FormattedNow(), "Scheduled", target.Name, "with", spec)
+	}
+
+	return nil
+}
+
+// Starts running the cronjobs.
+func (s *goferScheduler) start() {
+	s.cron.Start()
+}
+
+// Stops running the cronjobs. Jobs that are currently running are not interrupted.
+func (s *goferScheduler) stop() {
+	s.cron.Stop()
+}
@@ -0,0 +1,174 @@
+// This file handles the list of targets the gofers work on.
+// Targets are stored in the database (seeded from the config), so they can be managed from Discord,
+// and this keeps a copy of the enabled ones in memory for the gofers, the scheduler and the announcers.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bwmarrin/discordgo"
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/parsers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+	"github.com/robfig/cron/v3"
+)
+
+var activeTargets []types.Target
+var activeTargetsLock sync.RWMutex
+
+// Gets a copy of the enabled targets.
+func getTargets() []types.Target {
+	activeTargetsLock.RLock()
+	defer activeTargetsLock.RUnlock()
+
+	targets := make([]types.Target, len(activeTargets))
+	copy(targets, activeTargets)
+	return targets
+}
+
+// Finds an enabled target by its name.
+func findTarget(name string) *types.Target {
+	activeTargetsLock.RLock()
+	defer activeTargetsLock.RUnlock()
+
+	for i := range activeTargets {
+		if activeTargets[i].Name == name {
+			target := activeTargets[i]
+			return &target
+		}
+	}
+
+	return nil
+}
+
+// Reads the enabled targets from the database and reschedules the gofers accordingly.
+// This is called on startup and whenever the targets are changed.
+func reloadTargets() error {
+	entries, err := db.GetTargets()
+	if err != nil {
+		return err
+	}
+
+	var targets []types.Target
+	for _, entry := range entries {
+		if entry.Enabled {
+			targets = append(targets, entry.Target)
+		}
+	}
+
+	activeTargetsLock.Lock()
+	activeTargets = targets
+	activeTargetsLock.Unlock()
+
+	fmt.Println(helpers.FormattedNow(), "Loaded", len(targets), "enabled target(s)")
+	return scheduler.reschedule(getConfig().CronInterval, targets)
+}
+
+// Parses a target definition and checks it.
+// The definition is written as a TOML inline table, e.g.
+// { name = "Title", mode = "rss", source = "https://example.com/rss" }
+// The outer braces may be left out.
+func parseTargetDefinition(definition string) (types.Target, error) {
+	target, err := decodeTargetDefinition(definition)
+	if err != nil {
+		return target, err
+	}
+
+	return target, checkTarget(&target)
+}
+
+// Reads a target written either as a TOML inline table (like the /target-add command takes)
+// or as a [[targets]] entry from the config file. The target isn't checked.
+func decodeTargetDefinition(definition string) (types.Target, error) {
+	if strings.Contains(definition, "[[targets]]") {
+		var snippet struct {
+			Targets []types.Target
+		}
+		_, err := toml.Decode(definition, &snippet)
+		if err != nil {
+			return types.Target{}, err
+		}
+		if len(snippet.Targets) != 1 {
+			return types.Target{}, errors.New("the snippet must have exactly one [[targets]] entry")
+		}
+
+		return snippet.Targets[0], nil
+	}
+
+	var wrapper struct {
+		Target types.Target
+	}
+
+	definition = strings.TrimSpace(definition)
+	if !strings.HasPrefix(definition, "{") {
+		definition = "{ " + definition + " }"
+	}
+
+	_, err := toml.Decode("target = "+definition, &wrapper)
+	return wrapper.Target, err
+}
+
+// Checks a target for settings that won't work, and returns every problem found.
+func getTargetProblems(target *types.Target) []parsers.TargetProblem {
+	problems := parsers.ValidateTarget(target)
+	if target.Schedule != "" {
+		if _, err := cron.ParseStandard(toCronSpec(target.Schedule)); err != nil {
+			problems = append(problems, parsers.TargetProblem{Field: "schedule", Message: "is not a cron spec or an interval: \"" + target.Schedule + "\""})
+		}
+	}
+
+	return problems
+}
+
+// Checks a target, and returns its problems as a single error.
+func checkTarget(target *types.Target) error {
+	problems := getTargetProblems(target)
+	if len(problems) < 1 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		messages = append(messages, problem.Field+" "+problem.Message)
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Checks whether the user of an interaction is one of the bot admins in the config.
+// Targets are shared by every guild, so only bot admins may change them.
+func isAdmin(i *discordgo.InteractionCreate) bool {
+	var userId string
+	if i.Member != nil {
+		userId = i.Member.User.ID
+	} else if i.User != nil {
+		userId = i.User.ID
+	}
+
+	for _, admin := range getConfig().Admins {
+		if admin == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Describes a stored target in a single line, with the date it was last changed in the language and timezone.
+func describeTarget(entry types.TargetEntry, lang *language, location *time.Location) string {
+	line := "[" + entry.Target.Name + "] " + entry.Target.Mode + " " + entry.Target.Source
+	if entry.Target.Schedule != "" {
+		line += " (" + entry.Target.Schedule + ")"
+	}
+	if !entry.Enabled {
+		line += lang.text("target-is-disabled")
+	}
+	line += lang.text("target-updated-at", lang.formatDate(entry.UpdatedAt, location))
+
+	return line
+}
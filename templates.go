@@ -0,0 +1,149 @@
+// This file handles the announcement templates guilds can set instead of the default embed.
+// A template is a Go text/template that writes the message a chapter is announced with,
+// optionally followed by the default embed. A guild can set one for all its series and one for each series.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// The chapter as templates see it.
+type templateChapter struct {
+	Series      string
+	Number      string
+	Title       string
+	Url         string
+	Date        string    // In the guild's language and timezone
+	Time        time.Time // In the guild's timezone, for the date function
+	Thumbnail   string
+	Description string
+	Author      string
+	Access      string // In the guild's language, or empty if unknown
+	Free        bool
+	Paid        bool
+	Labels      []string
+}
+
+// The functions templates can use besides the built-in ones.
+var templateFunctions = template.FuncMap{
+	// Cuts a text down to a number of characters, like {{ .Description | truncate 100 }}
+	"truncate": func(length int, text string) string {
+		if length < 1 {
+			return ""
+		}
+		return truncateText(text, length)
+	},
+	// Formats a time with a Go layout, like {{ .Time | date "2006-01-02" }}
+	"date": func(layout string, date time.Time) string {
+		return date.Format(layout)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Parses the text of a template.
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("announcement").Funcs(templateFunctions).Option("missingkey=error").Parse(text)
+}
+
+// Gets the template a chapter of a series is announced with:
+// the one set for the series, or the one set for the guild, or nil for the default embed.
+func findTemplate(templates []types.Template, series string) *types.Template {
+	var found *types.Template
+	for i := range templates {
+		if templates[i].Series == series {
+			return &templates[i]
+		}
+		if templates[i].Series == "" {
+			found = &templates[i]
+		}
+	}
+
+	return found
+}
+
+// Fills in a template with a chapter, in the guild's language and timezone.
+func renderTemplate(tmpl *types.Template, server *types.Server, chapter *types.Chapter) (string, error) {
+	parsed, err := parseTemplate(tmpl.Text)
+	if err != nil {
+		return "", err
+	}
+
+	lang := getLanguage(server, nil)
+	location := getTimezone(server)
+	data := templateChapter{
+		Series:      chapter.Manga,
+		Number:      chapter.Number,
+		Title:       chapter.Title,
+		Url:         chapter.Url,
+		Date:        lang.formatDate(chapter.Date, location),
+		Time:        chapter.Date.In(location),
+		Thumbnail:   chapter.Thumbnail,
+		Description: chapter.Description,
+		Author:      chapter.Author,
+		Free:        chapter.Access == types.AccessFree,
+		Paid:        chapter.Access == types.AccessPaid,
+	}
+	if chapter.Access != "" {
+		data.Access = lang.text("access-" + chapter.Access)
+	}
+	if target := findTarget(chapter.Manga); target != nil {
+		data.Labels = target.Labels
+	}
+
+	var text strings.Builder
+	err = parsed.Execute(&text, data)
+	if err != nil {
+		return "", err
+	}
+
+	return truncateMessage(strings.TrimSpace(text.String())), nil
+}
+
+// Makes the message that announces a chapter with a template, or with the default embed if there's no template.
+// A template that fails or writes nothing without the embed falls back to the default embed,
+// so the chapter is still announced.
+func makeAnnouncement(server *types.Server, tmpl *types.Template, chapter *types.Chapter) *discordgo.MessageSend {
+	embed := makeChapterEmbed(server, chapter)
+	if tmpl == nil {
+		return &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}
+	}
+
+	content, err := renderTemplate(tmpl, server, chapter)
+	if err != nil {
+		fmt.Println(helpers.FormattedNow(), server.Identifier+":", "Template failed for ["+chapter.Manga+"]:", err.Error())
+		return &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}
+	}
+
+	message := &discordgo.MessageSend{Content: content}
+	if tmpl.Embed || content == "" {
+		message.Embeds = []*discordgo.MessageEmbed{embed}
+	}
+	return message
+}
+
+// Makes a chapter of a series to preview templates with.
+func makeSampleChapter(series string, lang *language) types.Chapter {
+	if series == "" {
+		series = "Decatholac"
+	}
+
+	return types.Chapter{
+		Manga:       series,
+		Number:      "12",
+		Title:       lang.text("sample-chapter-title"),
+		Url:         "https://example.com/episode/12",
+		Date:        time.Now(),
+		Description: lang.text("sample-chapter-description"),
+		Author:      "Mango",
+		Access:      types.AccessFree,
+	}
+}
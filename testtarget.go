@@ -0,0 +1,102 @@
+// This file handles test runs of targets: fetching and parsing a target once to see what it finds,
+// without saving anything to the database. It's used by the "test-target" subcommand and the web interface.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/types"
+)
+
+// The outcome of a test run.
+type targetTestResult struct {
+	Target     string
+	Problems   []string // What's wrong with the target's settings; it's still tried anyway
+	StatusCode int
+	Error      string
+	Chapters   []types.Chapter
+}
+
+// Fetches and parses a target once. Nothing is saved, and the fetch cache isn't used.
+func testTarget(ctx context.Context, target *types.Target) targetTestResult {
+	chapters, response, err := fetchChapters(ctx, target, nil)
+	result := makeTargetTestResult(target, chapters, err)
+	result.StatusCode = response.StatusCode
+
+	return result
+}
+
+// Parses a target's response recorded in the fixtures directory, instead of fetching its source.
+func testTargetWithFixture(directory string, target *types.Target) targetTestResult {
+	chapters, err := replayFixture(directory, target)
+	return makeTargetTestResult(target, chapters, err)
+}
+
+func makeTargetTestResult(target *types.Target, chapters []types.Chapter, err error) targetTestResult {
+	result := targetTestResult{Target: target.Name, Chapters: chapters}
+	for _, problem := range getTargetProblems(target) {
+		result.Problems = append(result.Problems, problem.Field+" "+problem.Message)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if result.Chapters == nil {
+		result.Chapters = []types.Chapter{}
+	}
+
+	return result
+}
+
+// Prints the result of a test run as a table.
+// Values that fell back to a default (like a date that's just the fetch time) are marked with an asterisk.
+func printTargetTestResult(w io.Writer, result targetTestResult) {
+	fmt.Fprintln(w, "Target:", result.Target)
+	for _, problem := range result.Problems {
+		fmt.Fprintln(w, "Problem:", problem)
+	}
+	if result.StatusCode != 0 {
+		fmt.Fprintln(w, "Status:", result.StatusCode)
+	}
+	if result.Error != "" {
+		fmt.Fprintln(w, "Error:", result.Error)
+	}
+	fmt.Fprintln(w, "Chapters:", len(result.Chapters))
+	if len(result.Chapters) < 1 {
+		return
+	}
+	fmt.Fprintln(w)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "#\tNumber\tTitle\tDate\tUrl")
+	defaulted := false
+	for index, chapter := range result.Chapters {
+		mark := func(field string, value string) string {
+			for _, f := range chapter.Defaulted {
+				if f == field {
+					defaulted = true
+					return value + " *"
+				}
+			}
+			return value
+		}
+
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\n",
+			index+1,
+			mark("Number", chapter.Number),
+			mark("Title", chapter.Title),
+			mark("Date", chapter.Date.Format(time.RFC3339)),
+			mark("Url", chapter.Url),
+		)
+	}
+	table.Flush()
+
+	if defaulted {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "* The source didn't have this value, so a default was used.")
+	}
+}
@@ -0,0 +1,12 @@
+package types
+
+// Settings of the HTTP client used for fetching sources.
+// It can be set globally and per target; the target's non-empty settings take precedence.
+type HttpConfig struct {
+	Timeout            string // e.g. "30s"
+	Proxy              string // e.g. "http://127.0.0.1:3128" or "socks5://127.0.0.1:1080"
+	UserAgent          string // Used unless the target's requestHeaders already set one
+	CookieFile         string // Path to a Netscape-format cookies.txt file
+	InsecureSkipVerify bool   // Skip TLS certificate verification (enabling it anywhere enables it)
+	MinTlsVersion      string // "1.0", "1.1", "1.2" or "1.3"
+}
@@ -3,17 +3,90 @@ package types
 import "time"
 
 type Chapter struct {
+	Id       int64 // Only set for chapters read from the database
 	Manga    string
 	Number   string
 	Title    string
 	Date     time.Time
 	Url      string
 	LoggedAt time.Time
+
+	// Optional details for the announcements; empty if the source doesn't have them
+	Thumbnail   string
+	Description string
+	Author      string
+	Access      string // AccessFree or AccessPaid, or empty if unknown
+
+	// The fields that fell back to a default because the source didn't have them, e.g. "Date" when it's the fetch time.
+	// This is only set by the parsers, and is not saved.
+	Defaulted []string
 }
 
+// Whether a chapter can be read for free.
+const (
+	AccessFree = "free"
+	AccessPaid = "paid"
+)
+
 type Server struct {
 	Identifier            string
 	FeedChannelIdentifier string
 	LastAnnouncedAt       time.Time
-	IsAnnouncing          bool
+	AnnouncingOwner       string    // Who holds the announcing lease, if anyone
+	AnnouncingUntil       time.Time // When the announcing lease expires and can be taken over
+	Timezone              string    // IANA name for the dates shown in the guild; the default if empty
+	Language              string    // Language code of the bot's replies ("en", "ja"); the default if empty
+	DigestMode            string    // One of the database.Digest* constants; announcing one at a time if empty
+	LastDigestAt          time.Time // When the last daily digest was sent
+}
+
+type FetchCache struct {
+	Target       string
+	ETag         string
+	LastModified string
+}
+
+type FetchRun struct {
+	Id               int64
+	Target           string
+	StartedAt        time.Time
+	FinishedAt       time.Time
+	HttpStatus       int // 0 if no response was received at all
+	Error            string
+	ChaptersFound    int // Before the target's filters
+	ChaptersParsed   int // After the target's filters
+	ChaptersInserted int
+}
+
+// A target as it's stored in the database.
+type TargetEntry struct {
+	Target    Target
+	Enabled   bool
+	Origin    string // Either "config" (seeded from config.toml) or "discord" (added or edited with commands)
+	UpdatedAt time.Time
+}
+
+type Delivery struct {
+	GuildId   string
+	ChannelId string
+	ChapterId int64
+	Status    string // One of the database.Delivery* constants
+	MessageId string
+	Error     string
+}
+
+// Sends the chapters of a series, or of every series with a label, to a channel instead of the feed channel.
+type Route struct {
+	GuildId   string
+	ChannelId string
+	Kind      string // One of the database.Route* constants
+	Value     string // The series title or the label
+}
+
+// How a guild's chapters are announced, instead of the default embed.
+type Template struct {
+	GuildId string
+	Series  string // The series it's used for, or empty for the rest of the guild's series
+	Text    string // A text/template for the message; may be empty if the embed is shown
+	Embed   bool   // Whether the default embed is sent along with the message
 }
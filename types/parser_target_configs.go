@@ -7,6 +7,13 @@ type Target struct {
 	Mode            string
 	BaseUrl         string
 	RequestHeaders  map[string]string
+	Schedule        string   // Cron spec or interval (e.g. "0 18 * * 5" or "6h"); uses the global cronInterval if empty
+	Labels          []string // Tags or genres, used to route the series' chapters to specific channels
+	Http            HttpConfig
+	Filters         Filters // Which chapters to keep; applies to every mode
+	Timezone        string  // IANA name (e.g. "Asia/Tokyo") for dates that don't have a timezone; UTC if empty
+	Color           string  // Color of the announcement embeds, e.g. "#e4007f"
+	Icon            string  // URL of an image shown next to the series' name in the announcement embeds
 
 	// JSON mode
 	Keys Keys
@@ -23,6 +30,13 @@ type Keys struct {
 	DateFormat string
 	Url        string
 	Skip       map[string]any
+
+	// Optional, for the announcements
+	Thumbnail   string
+	Description string
+	Author      string
+	Free        string // A value that's true (or a non-zero number, or a non-empty string) if the chapter is free to read
+	Paid        string // The other way around, e.g. a price
 }
 
 type Tags struct {
@@ -36,4 +50,37 @@ type Tags struct {
 	DateFormat      string
 	UrlTag          string
 	UrlAttribute    string
+
+	// Optional, for the announcements
+	ThumbnailTag         string
+	ThumbnailAttribute   string
+	DescriptionTag       string
+	DescriptionAttribute string
+	AuthorTag            string
+	AuthorAttribute      string
+	FreeTag              string // The chapter is free to read if it has an element like this (e.g. a "free" badge)
+	PaidTag              string // The chapter is paid if it has an element like this (e.g. a coin icon)
+}
+
+type Filters struct {
+	Include []Filter // A chapter is only kept if it matches every one of these
+	Exclude []Filter // A chapter is dropped if it matches any of these
+}
+
+// A condition on a parsed chapter. Every condition that's set must hold for the filter to match.
+type Filter struct {
+	Field    string // "title", "number", "url", "date", "author", "description" or "access" ("free" or "paid")
+	Match    string // A regular expression the field must match
+	Operator string // "==", "!=", "<", "<=", ">" or ">="; compares the field to Value
+	Value    string // Numbers are compared as numbers (the first number in the field), dates as dates, and the rest as text
+
+	// For the "date" field
+	OlderThan string // A duration like "30d" or "12h"
+	NewerThan string
+	Before    string // A date like "2023-04-01" or "2023-04-01T00:00:00+09:00"
+	After     string
+
+	All []Filter // Matches if every one of these matches
+	Any []Filter // Matches if any of these matches
+	Not *Filter  // Matches if this doesn't
 }
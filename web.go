@@ -3,13 +3,25 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hermitpopcorn/decatholac-mango/helpers"
+	"github.com/hermitpopcorn/decatholac-mango/types"
 )
 
-func startWebInterface() {
-	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+// Builds the handler of the web interface.
+func newWebHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		html, err := os.ReadFile("web_interface.html")
 		if err != nil {
 			log.Panicln(err.Error())
@@ -17,34 +29,141 @@ func startWebInterface() {
 		w.Write(html)
 	})
 
-	http.HandleFunc("/fetch", func(w http.ResponseWriter, req *http.Request) {
-		if currentlyFetchingTargets {
+	mux.HandleFunc("/fetch", func(w http.ResponseWriter, req *http.Request) {
+		if isFetchingAllTargets() {
 			w.Write([]byte("Fetching currently in progress."))
 			return
 		}
 
-		go startGofers(db, &config.Targets)
+		if !goWork(func(ctx context.Context) {
+			targets := getTargets()
+			startGofers(ctx, db, &targets)
+		}) {
+			w.Write([]byte("The bot is shutting down."))
+			return
+		}
 		w.Write([]byte("Fetch process started."))
 	})
 
-	http.HandleFunc("/announce", func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc("/announce", func(w http.ResponseWriter, req *http.Request) {
 		if session != nil {
-			go startAnnouncers(db)
+			if !goWork(func(ctx context.Context) { startAnnouncers(ctx, db) }) {
+				w.Write([]byte("The bot is shutting down."))
+				return
+			}
 			w.Write([]byte("Announcement process started."))
 		} else {
 			w.Write([]byte("Could not start announcement process: no Discord session."))
 		}
 	})
 
-	port := config.WebInterfacePort
-	if port == "" {
-		port = ":8080"
-	} else {
-		port = ":" + config.WebInterfacePort
+	// Lists the latest fetch run of every target,
+	// or the recent fetch runs of a single target if the "target" query is given
+	mux.HandleFunc("/runs", func(w http.ResponseWriter, req *http.Request) {
+		var runs []types.FetchRun
+		var err error
+
+		target := req.URL.Query().Get("target")
+		if target != "" {
+			limit, convErr := strconv.Atoi(req.URL.Query().Get("limit"))
+			if convErr != nil || limit < 1 {
+				limit = 20
+			}
+			runs, err = db.GetFetchRuns(target, limit)
+		} else {
+			runs, err = db.GetLatestFetchRuns()
+		}
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "Could not get the fetch runs.", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runs)
+	})
+
+	// Fetches and parses a stored target given by "name" without saving anything, and shows what it found.
+	// Definitions aren't taken here, since anyone who can reach the web interface could make the bot request any URL with one;
+	// they can be tested with the test-target subcommand or added with /target-add instead.
+	mux.HandleFunc("/test-target", func(w http.ResponseWriter, req *http.Request) {
+		name := req.FormValue("name")
+		if name == "" {
+			http.Error(w, "Give the name of a target.", http.StatusBadRequest)
+			return
+		}
+		entry, err := db.GetTarget(name)
+		if err != nil {
+			http.Error(w, "Target not found.", http.StatusNotFound)
+			return
+		}
+		target := entry.Target
+
+		ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
+		defer cancel()
+		result := testTarget(ctx, &target)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+
+	return mux
+}
+
+var webServer *http.Server
+var webServerLock sync.Mutex
+
+// Gets the address the web interface listens on.
+func getWebInterfacePort(c configuration) string {
+	if c.WebInterfacePort == "" {
+		return ":8080"
+	}
+
+	return ":" + c.WebInterfacePort
+}
+
+// Starts the web interface on the port in the config, stopping the previous one first if it's running.
+func restartWebInterface() {
+	webServerLock.Lock()
+	defer webServerLock.Unlock()
+
+	shutdownWebServer()
+
+	server := &http.Server{
+		Addr:    getWebInterfacePort(getConfig()),
+		Handler: newWebHandler(),
+	}
+	webServer = server
+
+	go func() {
+		fmt.Println(helpers.FormattedNow(), "Web interface listening on", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Println(err.Error())
+		}
+	}()
+}
+
+// Stops the web interface.
+func stopWebInterface() {
+	webServerLock.Lock()
+	defer webServerLock.Unlock()
+
+	shutdownWebServer()
+}
+
+// Stops the running web server, letting in-flight requests finish for a few seconds.
+// The caller must hold webServerLock.
+func shutdownWebServer() {
+	if webServer == nil {
+		return
 	}
 
-	err := http.ListenAndServe(port, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := webServer.Shutdown(ctx)
 	if err != nil {
 		log.Println(err.Error())
 	}
+	webServer = nil
 }